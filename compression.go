@@ -0,0 +1,40 @@
+package stacker
+
+import (
+	"io"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/anuvu/stacker/types"
+	"github.com/openSUSE/umoci"
+)
+
+// PutLayerTar writes tarStream to oci using l's `compression:` directive,
+// falling back to sc.LayerCompression and then "gzip", returning the
+// resulting layer descriptor - this is the only place a `compression:
+// zstd:chunked` stackerfile (or sc.LayerCompression build-wide default)
+// directive is actually honored; without it, umoci.PutChunkedLayer is
+// unreachable.
+func PutLayerTar(oci *umoci.Layout, tarStream io.Reader, sc types.StackerConfig, l *types.Layer) (ispec.Descriptor, error) {
+	compression, err := l.ParseCompression(sc)
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+
+	blob, annotations, mediaType, err := oci.PutLayer(tarStream, umoci.LayerCompression(compression))
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+
+	digest, err := blob.ToDigest()
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+
+	return ispec.Descriptor{
+		MediaType:   mediaType,
+		Digest:      digest,
+		Size:        blob.Size,
+		Annotations: annotations,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package stacker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/anuvu/stacker/types"
+	"github.com/openSUSE/umoci"
+)
+
+func TestPutLayerTarHonorsCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_compression_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oci, err := umoci.CreateLayout(path.Join(dir, "oci"))
+	if err != nil {
+		t.Fatalf("couldn't create OCI layout: %v", err)
+	}
+	defer oci.Close()
+
+	for _, tc := range []struct {
+		compression string
+		wantMedia   string
+	}{
+		{"", "application/vnd.oci.image.layer.v1.tar+gzip"},
+		{"gzip", "application/vnd.oci.image.layer.v1.tar+gzip"},
+		{"zstd", "application/vnd.oci.image.layer.v1.tar+zstd"},
+		{"zstd:chunked", "application/vnd.oci.image.layer.v1.tar+zstd"},
+	} {
+		l := &types.Layer{Compression: tc.compression}
+
+		desc, err := PutLayerTar(oci, bytes.NewReader(nil), types.StackerConfig{}, l)
+		if err != nil {
+			t.Fatalf("compression %q: PutLayerTar: %v", tc.compression, err)
+		}
+		if desc.MediaType != tc.wantMedia {
+			t.Errorf("compression %q: media type = %q, want %q", tc.compression, desc.MediaType, tc.wantMedia)
+		}
+	}
+}
+
+func TestPutLayerTarRejectsUnknownCompression(t *testing.T) {
+	l := &types.Layer{Compression: "brotli"}
+
+	if _, err := PutLayerTar(nil, bytes.NewReader(nil), types.StackerConfig{}, l); err == nil {
+		t.Fatalf("expected an error for an unsupported compression")
+	}
+}
+
+// TestPutLayerTarFallsBackToStackerConfigDefault checks that a
+// build-wide sc.LayerCompression default is honored by any layer that
+// doesn't set its own `compression:` directive, and that a layer's own
+// directive still takes precedence over it.
+func TestPutLayerTarFallsBackToStackerConfigDefault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_compression_default_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oci, err := umoci.CreateLayout(path.Join(dir, "oci"))
+	if err != nil {
+		t.Fatalf("couldn't create OCI layout: %v", err)
+	}
+	defer oci.Close()
+
+	sc := types.StackerConfig{LayerCompression: "zstd"}
+
+	desc, err := PutLayerTar(oci, bytes.NewReader(nil), sc, &types.Layer{})
+	if err != nil {
+		t.Fatalf("PutLayerTar: %v", err)
+	}
+	if want := "application/vnd.oci.image.layer.v1.tar+zstd"; desc.MediaType != want {
+		t.Errorf("media type = %q, want %q (from sc.LayerCompression)", desc.MediaType, want)
+	}
+
+	desc, err = PutLayerTar(oci, bytes.NewReader(nil), sc, &types.Layer{Compression: "gzip"})
+	if err != nil {
+		t.Fatalf("PutLayerTar: %v", err)
+	}
+	if want := "application/vnd.oci.image.layer.v1.tar+gzip"; desc.MediaType != want {
+		t.Errorf("media type = %q, want %q (layer's own compression: should win over sc.LayerCompression)", desc.MediaType, want)
+	}
+}
@@ -0,0 +1,195 @@
+// Package container_storage implements the types.Storage backend on top of
+// github.com/containers/storage, as an alternative to stacker's own
+// btrfs/overlay layer management. Selecting it (--storage-type=containers-storage)
+// lets stacker run on hosts without btrfs and share the layer cache that
+// podman/cri-o already populate.
+package container_storage
+
+import (
+	"io"
+
+	cstorage "github.com/containers/storage"
+	"github.com/containers/storage/pkg/archive"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/anuvu/stacker/types"
+)
+
+// Storage implements types.Storage by delegating layer management to a
+// github.com/containers/storage store.
+type Storage struct {
+	sc    types.StackerConfig
+	store cstorage.Store
+
+	// layers maps a stacker layer name to the containers/storage layer
+	// ID that represents its rootfs.
+	layers map[string]string
+}
+
+// NewStorage opens (creating if necessary) a containers/storage store
+// rooted at sc.RootFSDir, using driver as its graph driver (e.g. "overlay",
+// "vfs", "devicemapper", "zfs").
+func NewStorage(sc types.StackerConfig, driver string) (*Storage, error) {
+	opts := cstorage.StoreOptions{
+		GraphDriverName: driver,
+		GraphRoot:       sc.RootFSDir,
+		RunRoot:         sc.RootFSDir,
+	}
+
+	store, err := cstorage.GetStore(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "open containers/storage store")
+	}
+
+	s := &Storage{sc: sc, store: store, layers: map[string]string{}}
+	if err := s.reconcile(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// reconcile populates s.layers from whatever layers the underlying store
+// already has on disk. A `stacker build` invocation is a fresh process
+// each time, so without this, layers created by a previous run would be
+// invisible to Exists()/the cache even though they're still on disk.
+// createFrom always uses the stacker layer name as the containers/storage
+// layer ID, so the two are interchangeable here.
+func (s *Storage) reconcile() error {
+	existing, err := s.store.Layers()
+	if err != nil {
+		return errors.Wrap(err, "list existing layers")
+	}
+
+	for _, l := range existing {
+		s.layers[l.ID] = l.ID
+	}
+
+	return nil
+}
+
+// Create starts a new, empty layer for name with no parent.
+func (s *Storage) Create(name string) error {
+	return s.createFrom(name, "")
+}
+
+// Snapshot creates a new layer for target whose parent is the
+// containers/storage layer currently backing source.
+func (s *Storage) Snapshot(source string, target string) error {
+	parent, ok := s.layers[source]
+	if !ok {
+		return errors.Errorf("no such layer %s", source)
+	}
+
+	return s.createFrom(target, parent)
+}
+
+func (s *Storage) createFrom(name string, parent string) error {
+	// A previous process may already have created this layer; reconcile
+	// populates s.layers for those, so treat a hit as the cache reuse it
+	// is rather than erroring out of store.CreateLayer on a duplicate ID.
+	if s.Exists(name) {
+		return nil
+	}
+
+	layer, err := s.store.CreateLayer(name, parent, nil, "", true, nil)
+	if err != nil {
+		return errors.Wrapf(err, "create layer %s", name)
+	}
+
+	s.layers[name] = layer.ID
+	return nil
+}
+
+// Restore replaces the layer backing name with a fresh, empty layer whose
+// parent is the layer backing source, used when a cached build needs to
+// be re-materialized.
+func (s *Storage) Restore(name string, source string) error {
+	parent, ok := s.layers[source]
+	if !ok {
+		return errors.Errorf("no such layer %s", source)
+	}
+
+	if err := s.Delete(name); err != nil {
+		return err
+	}
+
+	return s.createFrom(name, parent)
+}
+
+// Delete removes the containers/storage layer backing name, if any.
+func (s *Storage) Delete(name string) error {
+	id, ok := s.layers[name]
+	if !ok {
+		return nil
+	}
+
+	if err := s.store.DeleteLayer(id); err != nil {
+		return errors.Wrapf(err, "delete layer %s", name)
+	}
+
+	delete(s.layers, name)
+	return nil
+}
+
+// Exists reports whether name currently has a backing containers/storage
+// layer.
+func (s *Storage) Exists(name string) bool {
+	_, ok := s.layers[name]
+	return ok
+}
+
+// PutLayer feeds an uncompressed tar stream into the containers/storage
+// layer backing name, recording it as that layer's diff.
+func (s *Storage) PutLayer(name string, diff io.Reader) (digest.Digest, int64, error) {
+	id, ok := s.layers[name]
+	if !ok {
+		return "", 0, errors.Errorf("no such layer %s", name)
+	}
+
+	size, err := s.store.ApplyDiff(id, diff)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "apply diff to layer %s", name)
+	}
+
+	d, err := s.store.LayerDigest(id)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "get digest of layer %s", name)
+	}
+
+	return d, size, nil
+}
+
+// Diff returns the uncompressed tar diff of the layer backing name against
+// its parent, for translating a stacker layer into an OCI blob.
+func (s *Storage) Diff(name string) (io.ReadCloser, error) {
+	id, ok := s.layers[name]
+	if !ok {
+		return nil, errors.Errorf("no such layer %s", name)
+	}
+
+	return s.store.Diff("", id, &cstorage.DiffOptions{Compression: archive.Uncompressed})
+}
+
+// RootfsPath returns the host path to the (mounted) rootfs backing name.
+func (s *Storage) RootfsPath(name string) (string, error) {
+	id, ok := s.layers[name]
+	if !ok {
+		return "", errors.Errorf("no such layer %s", name)
+	}
+
+	return s.store.Mount(id, "")
+}
+
+// Close releases any held mounts and closes the underlying store.
+func (s *Storage) Close() error {
+	for name, id := range s.layers {
+		if _, err := s.store.Unmount(id, false); err != nil {
+			return errors.Wrapf(err, "unmount layer %s", name)
+		}
+	}
+
+	_, err := s.store.Shutdown(false)
+	return err
+}
@@ -0,0 +1,53 @@
+package container_storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/anuvu/stacker/types"
+)
+
+// TestStorageReconcilesAcrossRestarts checks that a Storage opened against
+// a store with pre-existing layers (as left behind by an earlier stacker
+// process) can see and reuse them immediately, instead of only seeing
+// layers it created itself in-process.
+func TestStorageReconcilesAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_container_storage_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sc := types.StackerConfig{RootFSDir: dir}
+
+	s1, err := NewStorage(sc, "vfs")
+	if err != nil {
+		t.Fatalf("couldn't open storage: %v", err)
+	}
+
+	if err := s1.Create("centos"); err != nil {
+		t.Fatalf("couldn't create layer: %v", err)
+	}
+	if err := s1.Close(); err != nil {
+		t.Fatalf("couldn't close storage: %v", err)
+	}
+
+	// Simulate a fresh `stacker build` process reopening the same store.
+	s2, err := NewStorage(sc, "vfs")
+	if err != nil {
+		t.Fatalf("couldn't reopen storage: %v", err)
+	}
+	defer s2.Close()
+
+	if !s2.Exists("centos") {
+		t.Fatalf("layer created by a previous process should still be visible")
+	}
+
+	// Re-creating a layer stacker already knows about (e.g. a rebuild
+	// that hits the cache) must not error just because the underlying
+	// containers/storage layer already exists.
+	if err := s2.Create("centos"); err != nil {
+		t.Fatalf("re-creating an already-existing layer should be a no-op: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package stacker
+
+import (
+	"strings"
+
+	"github.com/anuvu/stacker/types"
+	"github.com/openSUSE/umoci"
+	igen "github.com/openSUSE/umoci/oci/config/generate"
+	"github.com/openSUSE/umoci/oci/layer"
+)
+
+// encryptionConfigFor converts layer's parsed `encrypt:` directive into the
+// umoci.EncryptionConfig that NewEncryptedImage expects, sniffing each
+// recipient's key material to decide which keywrap protocol it needs. It
+// returns nil if the layer isn't encrypted.
+func encryptionConfigFor(l *types.Layer) (*umoci.EncryptionConfig, error) {
+	enc, err := l.ParseEncrypt()
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, nil
+	}
+
+	recipients := make([]umoci.Recipient, 0, len(enc.Recipients))
+	for _, r := range enc.Recipients {
+		recipients = append(recipients, umoci.Recipient{
+			Type: sniffRecipientType(r),
+			Key:  []byte(r),
+		})
+	}
+
+	return &umoci.EncryptionConfig{Recipients: recipients, Algorithm: enc.Algorithm}, nil
+}
+
+// sniffRecipientType guesses a recipient's keywrap protocol from its key
+// material: PGP and X.509 recipients are armored/PEM text, so anything
+// else is assumed to be a JWE recipient's JWK.
+func sniffRecipientType(recipient string) umoci.RecipientType {
+	switch {
+	case strings.HasPrefix(recipient, "-----BEGIN PGP PUBLIC KEY"):
+		return umoci.RecipientPGP
+	case strings.HasPrefix(recipient, "-----BEGIN CERTIFICATE"):
+		return umoci.RecipientPKCS7
+	default:
+		return umoci.RecipientJWE
+	}
+}
+
+// NewLayerImage tags layers as tagName in oci, encrypting them first if l
+// has an `encrypt:` directive, so that the `encrypt:` stackerfile
+// directive actually takes effect on the built image rather than being
+// silently ignored. It does not attach SBOMs; see MaybeAttachSbom.
+func NewLayerImage(oci *umoci.Layout, tagName string, g *igen.Generator, layers []umoci.Blob, mediaType string, l *types.Layer) error {
+	enc, err := encryptionConfigFor(l)
+	if err != nil {
+		return err
+	}
+
+	if enc == nil {
+		return oci.NewImage(tagName, g, layers, mediaType)
+	}
+
+	return oci.NewEncryptedImage(tagName, g, layers, mediaType, enc)
+}
+
+// UnpackLayerImage unpacks tag from oci into path, decrypting it first if
+// dc is non-nil and the image was produced with an `encrypt:` directive.
+func UnpackLayerImage(oci *umoci.Layout, tag string, path string, mo *layer.MapOptions, dc *umoci.DecryptionConfig) error {
+	if dc == nil {
+		return oci.Unpack(tag, path, mo)
+	}
+
+	return oci.UnpackEncrypted(tag, path, mo, dc)
+}
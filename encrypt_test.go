@@ -0,0 +1,54 @@
+package stacker
+
+import (
+	"testing"
+
+	"github.com/anuvu/stacker/types"
+	"github.com/openSUSE/umoci"
+)
+
+func TestEncryptionConfigForNoDirective(t *testing.T) {
+	l := &types.Layer{}
+
+	enc, err := encryptionConfigFor(l)
+	if err != nil {
+		t.Fatalf("encryptionConfigFor: %v", err)
+	}
+	if enc != nil {
+		t.Fatalf("expected nil EncryptionConfig for a layer with no encrypt: directive")
+	}
+}
+
+func TestEncryptionConfigForSniffsRecipientTypes(t *testing.T) {
+	l := &types.Layer{
+		Encrypt: &types.EncryptionConfig{
+			Recipients: []string{
+				"-----BEGIN PGP PUBLIC KEY BLOCK-----\n...",
+				"-----BEGIN CERTIFICATE-----\n...",
+				"{\"kty\":\"RSA\"}",
+			},
+			Algorithm: "aes-256-gcm",
+		},
+	}
+
+	enc, err := encryptionConfigFor(l)
+	if err != nil {
+		t.Fatalf("encryptionConfigFor: %v", err)
+	}
+	if enc == nil {
+		t.Fatalf("expected a non-nil EncryptionConfig")
+	}
+	if enc.Algorithm != "aes-256-gcm" {
+		t.Errorf("algorithm = %q, want aes-256-gcm", enc.Algorithm)
+	}
+
+	want := []umoci.RecipientType{umoci.RecipientPGP, umoci.RecipientPKCS7, umoci.RecipientJWE}
+	if len(enc.Recipients) != len(want) {
+		t.Fatalf("got %d recipients, want %d", len(enc.Recipients), len(want))
+	}
+	for i, r := range enc.Recipients {
+		if r.Type != want[i] {
+			t.Errorf("recipient %d: type = %q, want %q", i, r.Type, want[i])
+		}
+	}
+}
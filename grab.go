@@ -6,10 +6,11 @@ import (
 	"path"
 
 	"github.com/anuvu/stacker/types"
+	"github.com/anuvu/stacker/verify"
 	"github.com/pkg/errors"
 )
 
-func Grab(sc types.StackerConfig, storage types.Storage, name string, source string, targetDir string, hash string) error {
+func Grab(sc types.StackerConfig, storage types.Storage, name string, source string, targetDir string, im types.ImportMap) error {
 	c, err := NewContainer(sc, storage, name)
 	if err != nil {
 		return err
@@ -22,10 +23,22 @@ func Grab(sc types.StackerConfig, storage types.Storage, name string, source str
 	}
 	defer os.Remove(path.Join(sc.RootFSDir, name, "rootfs", "stacker"))
 
-	if len(hash) > 0 {
-		if err = c.Execute(fmt.Sprintf("echo %s %s | sha256sum --check", hash, source), nil); err != nil {
+	if len(im.Hash) > 0 {
+		if err = c.Execute(fmt.Sprintf("echo %s %s | sha256sum --check", im.Hash, source), nil); err != nil {
 			return errors.Errorf("The requested hash of %s import is different than the actual hash: %s",
-				source, hash)
+				source, im.Hash)
+		}
+	}
+
+	if len(im.Sigstore) > 0 {
+		opts := verify.Options{
+			Sigstore:  im.Sigstore,
+			Cert:      im.Cert,
+			Identity:  im.Identity,
+			CosignKey: im.CosignKey,
+		}
+		if err = verify.Verify(sc, source, opts); err != nil {
+			return errors.Wrapf(err, "signature verification of %s import failed", source)
 		}
 	}
 
@@ -0,0 +1,38 @@
+package stacker
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/anuvu/stacker/runtime_image"
+	"github.com/anuvu/stacker/types"
+)
+
+// OutputTypeOCI is the default build output: just the OCI layout on disk.
+// OutputTypeContainerd and OutputTypeCRI additionally push the built tag
+// into a running containerd or CRI runtime via runtime_image.Push.
+const (
+	OutputTypeOCI        = "oci"
+	OutputTypeContainerd = "containerd"
+	OutputTypeCRI        = "cri"
+)
+
+// PushOutput implements `--output-type`: besides the OCI layout stacker
+// always produces at layoutPath, containerd/cri output types additionally
+// push tagName into the runtime described by dest.
+func PushOutput(outputType string, layoutPath string, tagName string, dest types.ImageSource) error {
+	switch outputType {
+	case "", OutputTypeOCI:
+		return nil
+
+	case OutputTypeContainerd:
+		dest.Type = types.ContainerdLayer
+		return runtime_image.Push(dest, layoutPath, tagName)
+
+	case OutputTypeCRI:
+		dest.Type = types.CRILayer
+		return runtime_image.Push(dest, layoutPath, tagName)
+
+	default:
+		return errors.Errorf("unknown output type %q", outputType)
+	}
+}
@@ -0,0 +1,35 @@
+package stacker
+
+import (
+	"testing"
+
+	"github.com/anuvu/stacker/types"
+)
+
+func TestPushOutputOCIIsNoop(t *testing.T) {
+	for _, outputType := range []string{"", OutputTypeOCI} {
+		if err := PushOutput(outputType, "/does/not/exist", "tag", types.ImageSource{}); err != nil {
+			t.Errorf("output type %q should be a no-op, got: %v", outputType, err)
+		}
+	}
+}
+
+func TestPushOutputUnknownType(t *testing.T) {
+	if err := PushOutput("bogus", "/does/not/exist", "tag", types.ImageSource{}); err == nil {
+		t.Fatalf("expected an error for an unknown output type")
+	}
+}
+
+func TestPushOutputDispatchesToRuntime(t *testing.T) {
+	// Push must still be reached (and fail: dest has no namespace for
+	// containerd, and there is no layout at /does/not/exist for either)
+	// rather than PushOutput silently treating containerd/cri like the
+	// oci no-op case.
+	dest := types.ImageSource{Url: "unix:///no/such/socket", Ref: "test"}
+
+	for _, outputType := range []string{OutputTypeContainerd, OutputTypeCRI} {
+		if err := PushOutput(outputType, "/does/not/exist", "tag", dest); err == nil {
+			t.Errorf("output type %q should have propagated the push failure", outputType)
+		}
+	}
+}
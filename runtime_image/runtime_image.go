@@ -0,0 +1,241 @@
+// Package runtime_image implements stacker's `containerd` and `cri`
+// ImageSource types: pulling a stackerfile's `from:` base image straight
+// out of a running containerd or CRI-O node's image store, and pushing a
+// freshly built OCI layout back into it for `--output-type
+// containerd|cri`. This lets a developer iterate on stacker-built images
+// on a Kubernetes node without a local registry round-trip.
+//
+// containerd's CRI plugin shares its native gRPC socket with the
+// RuntimeService/ImageService the kubelet talks to, and stores everything
+// in its own content store under the fixed "k8s.io" namespace. So for both
+// ImageSource types we ultimately speak containerd's native `images` gRPC
+// service against that namespace; for the `cri` type we first make a CRI
+// PullImage call (an error from it aborts Pull), so the image is cached
+// for the sandbox to use even if the containerd-side read-back below
+// turns out not to be possible. If it isn't - a pure CRI-O node doesn't expose
+// containerd's native API at all - Pull fails rather than returning
+// success with no usable OCI layout at layoutPath.
+package runtime_image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/anuvu/stacker/types"
+	"github.com/openSUSE/umoci"
+)
+
+// k8sNamespace is the containerd namespace the CRI plugin always uses,
+// regardless of which Kubernetes namespace the pod lives in.
+const k8sNamespace = "k8s.io"
+
+// Pull fetches src.Ref out of the containerd or CRI runtime reachable at
+// src.Url and unpacks it into a fresh OCI layout at layoutPath, so the rest
+// of stacker can treat it like any other `from:` image.
+func Pull(src types.ImageSource, layoutPath string) error {
+	if src.Ref == "" {
+		return errors.Errorf("%s source requires a ref", src.Type)
+	}
+
+	namespace := src.Namespace
+	if src.Type == types.CRILayer {
+		// Make sure the image is cached for the sandbox to use even if
+		// the read-back below turns out not to be possible (e.g. a pure
+		// CRI-O socket, which doesn't speak containerd's native images
+		// API at all). A failure of this CRI pull itself aborts Pull.
+		if err := criPullImage(src); err != nil {
+			return err
+		}
+		namespace = k8sNamespace
+	}
+
+	if namespace == "" {
+		return errors.Errorf("containerd source requires a namespace")
+	}
+
+	client, err := containerd.New(src.Url)
+	if err != nil {
+		return errors.Wrapf(err, "dial containerd socket %s", src.Url)
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), namespace)
+
+	img, err := client.GetImage(ctx, src.Ref)
+	if err != nil {
+		if img, err = client.Pull(ctx, src.Ref); err != nil {
+			if src.Type == types.CRILayer {
+				return errors.Wrapf(err, "%s was pulled into the CRI runtime's image cache, but stacker couldn't read it back as an OCI layout over the containerd API at %s - this socket may not be a containerd-backed CRI implementation (e.g. CRI-O)", src.Ref, src.Url)
+			}
+			return errors.Wrapf(err, "pull %s from containerd", src.Ref)
+		}
+	}
+
+	manifest, err := images.Manifest(ctx, client.ContentStore(), img.Target(), platforms.Default())
+	if err != nil {
+		return errors.Wrap(err, "resolve manifest")
+	}
+
+	layout, err := umoci.CreateLayout(layoutPath)
+	if err != nil {
+		return errors.Wrapf(err, "create OCI layout %s", layoutPath)
+	}
+	defer layout.Close()
+
+	if err := copyFromContentStore(ctx, client.ContentStore(), layout, manifest.Config); err != nil {
+		return errors.Wrap(err, "copy config")
+	}
+
+	for _, l := range manifest.Layers {
+		if err := copyFromContentStore(ctx, client.ContentStore(), layout, l); err != nil {
+			return errors.Wrapf(err, "copy layer %s", l.Digest)
+		}
+	}
+
+	return errors.Wrap(layout.PutManifest(manifest, src.Ref), "tag pulled image")
+}
+
+// Push imports the tag tagName out of the OCI layout at layoutPath into
+// the containerd or CRI runtime described by dest, naming it dest.Ref, so
+// `crictl images`/`ctr images ls` see the build's output immediately.
+func Push(dest types.ImageSource, layoutPath string, tagName string) error {
+	if dest.Ref == "" {
+		return errors.Errorf("%s destination requires a ref", dest.Type)
+	}
+
+	namespace := dest.Namespace
+	if dest.Type == types.CRILayer {
+		namespace = k8sNamespace
+	}
+
+	if namespace == "" {
+		return errors.Errorf("containerd destination requires a namespace")
+	}
+
+	layout, err := umoci.OpenLayout(layoutPath)
+	if err != nil {
+		return errors.Wrapf(err, "open OCI layout %s", layoutPath)
+	}
+	defer layout.Close()
+
+	manifest, err := layout.LookupManifest(tagName)
+	if err != nil {
+		return errors.Wrapf(err, "lookup %s in %s", tagName, layoutPath)
+	}
+
+	client, err := containerd.New(dest.Url)
+	if err != nil {
+		return errors.Wrapf(err, "dial containerd socket %s", dest.Url)
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), namespace)
+	store := client.ContentStore()
+
+	if err := copyToContentStore(ctx, store, layout, manifest.Config); err != nil {
+		return errors.Wrap(err, "copy config")
+	}
+
+	for _, l := range manifest.Layers {
+		if err := copyToContentStore(ctx, store, layout, l); err != nil {
+			return errors.Wrapf(err, "copy layer %s", l.Digest)
+		}
+	}
+
+	manifestDesc, err := writeJSONBlob(ctx, store, manifest, ispec.MediaTypeImageManifest)
+	if err != nil {
+		return errors.Wrap(err, "write manifest blob")
+	}
+
+	_, err = client.ImageService().Create(ctx, images.Image{Name: dest.Ref, Target: manifestDesc})
+	return errors.Wrapf(err, "create image %s", dest.Ref)
+}
+
+// copyFromContentStore copies desc out of store and into layout, for
+// turning a pulled containerd image into an OCI layout.
+func copyFromContentStore(ctx context.Context, store content.Provider, layout *umoci.Layout, desc ispec.Descriptor) error {
+	data, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return err
+	}
+
+	_, err = layout.PutBlob(bytes.NewReader(data))
+	return err
+}
+
+// copyToContentStore copies desc out of layout and into store, for
+// pushing a built OCI layout into containerd's image store.
+func copyToContentStore(ctx context.Context, store content.Store, layout *umoci.Layout, desc ispec.Descriptor) error {
+	data, err := layout.ReadBlob(desc)
+	if err != nil {
+		return err
+	}
+
+	return content.WriteBlob(ctx, store, desc.Digest.String(), bytes.NewReader(data),
+		ispec.Descriptor{Size: int64(len(data)), Digest: desc.Digest})
+}
+
+// writeJSONBlob marshals v, writes it into store and returns the
+// descriptor that refers to it.
+func writeJSONBlob(ctx context.Context, store content.Store, v interface{}, mediaType string) (ispec.Descriptor, error) {
+	data, err := marshalCanonicalJSON(v)
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+
+	dgst := digest.FromBytes(data)
+	desc := ispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+
+	if err := content.WriteBlob(ctx, store, dgst.String(), bytes.NewReader(data), desc); err != nil {
+		return ispec.Descriptor{}, err
+	}
+
+	return desc, nil
+}
+
+// criPullImage asks the CRI runtime at src.Url to pull src.Ref via the
+// kubelet-facing ImageService, so that a pure CRI-O node (whose store
+// isn't readable through the containerd API) at least has the image
+// cached for the sandbox to use, even though stacker can't read it back.
+func criPullImage(src types.ImageSource) error {
+	conn, err := grpc.Dial(src.Url, grpc.WithInsecure(), grpc.WithContextDialer(dialUnix))
+	if err != nil {
+		return errors.Wrapf(err, "dial CRI socket %s", src.Url)
+	}
+	defer conn.Close()
+
+	client := runtimeapi.NewImageServiceClient(conn)
+	_, err = client.PullImage(context.Background(), &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: src.Ref},
+	})
+	return errors.Wrapf(err, "CRI PullImage %s", src.Ref)
+}
+
+// dialUnix dials a "unix:///path/to.sock"-style containerd/CRI endpoint
+// for grpc.DialContext, stripping the scheme grpc itself doesn't expect.
+func dialUnix(ctx context.Context, addr string) (net.Conn, error) {
+	addr = strings.TrimPrefix(addr, "unix://")
+	d := net.Dialer{}
+	return d.DialContext(ctx, "unix", addr)
+}
+
+// marshalCanonicalJSON serializes v the same way the OCI spec requires
+// manifests to be serialized: no extra whitespace, so its digest is
+// reproducible.
+func marshalCanonicalJSON(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
@@ -0,0 +1,73 @@
+package runtime_image
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/anuvu/stacker/types"
+)
+
+// fakeCRIImageService answers PullImage successfully and nothing else,
+// standing in for a CRI-O socket that doesn't speak containerd's native
+// `images` gRPC API.
+type fakeCRIImageService struct {
+	runtimeapi.UnimplementedImageServiceServer
+}
+
+func (f *fakeCRIImageService) PullImage(ctx context.Context, req *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+	return &runtimeapi.PullImageResponse{ImageRef: req.Image.Image}, nil
+}
+
+func criOnlySocket(t *testing.T, dir string) string {
+	t.Helper()
+
+	sockPath := filepath.Join(dir, "cri.sock")
+	lis, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("couldn't listen on %s: %v", sockPath, err)
+	}
+
+	srv := grpc.NewServer()
+	runtimeapi.RegisterImageServiceServer(srv, &fakeCRIImageService{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return "unix://" + sockPath
+}
+
+// TestPullFailsLoudlyWhenCRISocketCannotBeReadBack is the regression test
+// for the bug where Pull, for a CRI source whose socket doesn't speak
+// containerd's native images API (e.g. a real CRI-O node), returned nil
+// after the best-effort CRI pull without ever writing anything to
+// layoutPath - silently "succeeding" with no usable OCI layout. It must
+// instead fail loudly, since callers rely on Pull's success meaning
+// layoutPath contains a layout they can build on top of.
+func TestPullFailsLoudlyWhenCRISocketCannotBeReadBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_runtime_image_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := types.ImageSource{
+		Type: types.CRILayer,
+		Url:  criOnlySocket(t, dir),
+		Ref:  "docker.io/library/centos:latest",
+	}
+
+	layoutPath := filepath.Join(dir, "oci")
+	if err := Pull(src, layoutPath); err == nil {
+		t.Fatalf("Pull should fail when the CRI socket can't be read back over the containerd API")
+	}
+
+	if _, err := os.Stat(layoutPath); err == nil {
+		t.Fatalf("Pull shouldn't leave a layout behind at %s when it failed", layoutPath)
+	}
+}
@@ -0,0 +1,36 @@
+package stacker
+
+import (
+	"io"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/anuvu/stacker/sbom"
+	"github.com/anuvu/stacker/types"
+	"github.com/openSUSE/umoci"
+)
+
+// MaybeAttachSbom attaches an SPDX and a CycloneDX SBOM to imageManifest in
+// oci when l has `generate_sbom: true` set; it's a no-op otherwise. This is
+// the only place `generate_sbom:` actually takes effect - without it, the
+// flag is parsed but never acted on.
+func MaybeAttachSbom(oci *umoci.Layout, l *types.Layer, tagName string, imageManifest ispec.Descriptor, parentRootfs string, rootfs string) error {
+	if !l.GenerateSbom {
+		return nil
+	}
+
+	return sbom.Attach(oci, tagName, imageManifest, parentRootfs, rootfs, tagName)
+}
+
+// Sbom implements `stacker sbom <tag>`: it opens the OCI layout at
+// layoutPath and writes tag's attached SBOM documents to w.
+func Sbom(layoutPath string, tag string, w io.Writer) error {
+	oci, err := umoci.OpenLayout(layoutPath)
+	if err != nil {
+		return errors.Wrapf(err, "open OCI layout %s", layoutPath)
+	}
+	defer oci.Close()
+
+	return sbom.Print(oci, tag, w)
+}
@@ -0,0 +1,60 @@
+package sbom
+
+import (
+	"bytes"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/openSUSE/umoci"
+)
+
+const (
+	// MediaTypeSPDX is the artifact/blob media type for an SPDX-JSON
+	// SBOM document, per the SPDX 2.3 spec's registered media type.
+	MediaTypeSPDX = "application/spdx+json"
+	// MediaTypeCycloneDX is the artifact/blob media type for a
+	// CycloneDX-JSON SBOM document.
+	MediaTypeCycloneDX = "application/vnd.cyclonedx+json"
+)
+
+// Attach scans rootfs (or, if parentRootfs is non-empty, just the
+// packages newly introduced since parentRootfs), builds both an
+// SPDX-JSON and a CycloneDX-JSON document named image, and attaches each
+// to layout as an OCI 1.1 referrers artifact manifest whose subject is
+// imageManifest - the descriptor of the image the SBOMs describe.
+func Attach(layout *umoci.Layout, image string, imageManifest ispec.Descriptor, parentRootfs string, rootfs string, tagPrefix string) error {
+	pkgs, err := ScanDiff(parentRootfs, rootfs)
+	if err != nil {
+		return errors.Wrap(err, "scan rootfs for packages")
+	}
+
+	spdxDoc, err := BuildSPDX(image, pkgs)
+	if err != nil {
+		return errors.Wrap(err, "build SPDX document")
+	}
+
+	if err := attachDocument(layout, MediaTypeSPDX, spdxDoc, imageManifest, tagPrefix+"-spdx"); err != nil {
+		return errors.Wrap(err, "attach SPDX SBOM")
+	}
+
+	cdxDoc, err := BuildCycloneDX(image, pkgs)
+	if err != nil {
+		return errors.Wrap(err, "build CycloneDX document")
+	}
+
+	if err := attachDocument(layout, MediaTypeCycloneDX, cdxDoc, imageManifest, tagPrefix+"-cyclonedx"); err != nil {
+		return errors.Wrap(err, "attach CycloneDX SBOM")
+	}
+
+	return nil
+}
+
+func attachDocument(layout *umoci.Layout, mediaType string, doc []byte, subject ispec.Descriptor, tagName string) error {
+	blob, err := layout.PutBlob(bytes.NewReader(doc))
+	if err != nil {
+		return errors.Wrap(err, "put SBOM blob")
+	}
+
+	return layout.PutArtifactManifest(mediaType, []umoci.Blob{blob}, subject, nil, tagName)
+}
@@ -0,0 +1,140 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SPDXDocument is the minimal subset of the SPDX 2.3 JSON schema stacker
+// emits: enough for `stacker sbom` and downstream scanners to enumerate
+// packages, without attempting full SPDX relationship/license modeling.
+type SPDXDocument struct {
+	SPDXVersion       string         `json:"spdxVersion"`
+	DataLicense       string         `json:"dataLicense"`
+	SPDXID            string         `json:"SPDXID"`
+	Name              string         `json:"name"`
+	DocumentNamespace string         `json:"documentNamespace"`
+	CreationInfo      spdxCreateInfo `json:"creationInfo"`
+	Packages          []spdxPackage  `json:"packages"`
+	Relationships     []spdxRelation `json:"relationships"`
+}
+
+type spdxCreateInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelation struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// BuildSPDX renders pkgs as an SPDX-JSON document describing image, with
+// a DESCRIBES relationship from the document to each package.
+func BuildSPDX(image string, pkgs []Package) ([]byte, error) {
+	doc := SPDXDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              image,
+		DocumentNamespace: "https://stacker.dev/spdx/" + image,
+		CreationInfo: spdxCreateInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: stacker-sbom"},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelation{},
+	}
+
+	for i, p := range pkgs {
+		id := spdxPackageID(i)
+		doc.Packages = append(doc.Packages, spdxPackage{
+			SPDXID:           id,
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.Purl,
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelation{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: id,
+		})
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// spdxPackageID returns the conventional SPDXID form most SPDX tooling
+// emits for the i'th package; SPDXIDs only need to be unique within the
+// document.
+func spdxPackageID(i int) string {
+	return fmt.Sprintf("SPDXRef-Package-%d", i)
+}
+
+// CycloneDXDocument is the minimal subset of the CycloneDX 1.5 JSON schema
+// stacker emits: a flat component list, without dependency graph or
+// vulnerability data.
+type CycloneDXDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cyclonedxMetadata    `json:"metadata"`
+	Components  []cyclonedxComponent `json:"components"`
+}
+
+type cyclonedxMetadata struct {
+	Component cyclonedxComponent `json:"component"`
+}
+
+type cyclonedxComponent struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Purl    string `json:"purl,omitempty"`
+}
+
+// BuildCycloneDX renders pkgs as a CycloneDX-JSON document describing
+// image as its top-level "container" component.
+func BuildCycloneDX(image string, pkgs []Package) ([]byte, error) {
+	doc := CycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cyclonedxMetadata{
+			Component: cyclonedxComponent{Type: "container", Name: image},
+		},
+		Components: []cyclonedxComponent{},
+	}
+
+	for _, p := range pkgs {
+		doc.Components = append(doc.Components, cyclonedxComponent{
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			Purl:    p.Purl,
+		})
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
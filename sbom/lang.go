@@ -0,0 +1,156 @@
+package sbom
+
+import (
+	"bufio"
+	"debug/buildinfo"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scanGoBinaries walks rootfs looking for Go binaries and reads each one's
+// embedded module dependency list, the same information `go version -m`
+// prints. Non-Go (or non-executable) files are skipped rather than
+// treated as errors, since most of a rootfs isn't a Go binary.
+func scanGoBinaries(rootfs string) ([]Package, error) {
+	var pkgs []Package
+
+	err := filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			return nil
+		}
+
+		bi, err := buildinfo.ReadFile(path)
+		if err != nil {
+			// Not a Go binary; not an error for our purposes.
+			return nil
+		}
+
+		for _, dep := range bi.Deps {
+			m := dep
+			if dep.Replace != nil {
+				m = dep.Replace
+			}
+			pkgs = append(pkgs, Package{
+				Name:    m.Path,
+				Version: m.Version,
+				Type:    "golang",
+				Purl:    purl("golang", m.Path, m.Version),
+			})
+		}
+
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return pkgs, err
+}
+
+// scanPython walks rootfs for installed wheel/sdist metadata: every
+// *.dist-info/METADATA or *.egg-info/PKG-INFO file under a site-packages
+// directory, both RFC822-style "Key: value" header blocks.
+func scanPython(rootfs string) ([]Package, error) {
+	var pkgs []Package
+
+	for _, meta := range []struct{ name, dirSuffix string }{
+		{"METADATA", ".dist-info"},
+		{"PKG-INFO", ".egg-info"},
+	} {
+		err := walkFiles(rootfs, meta.name, func(path string) error {
+			dir := filepath.Dir(path)
+			if !strings.HasSuffix(dir, meta.dirSuffix) ||
+				!strings.Contains(dir, string(filepath.Separator)+"site-packages"+string(filepath.Separator)) {
+				return nil
+			}
+
+			name, version, err := readPythonMetadata(path)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				return nil
+			}
+
+			pkgs = append(pkgs, Package{
+				Name:    name,
+				Version: version,
+				Type:    "python",
+				Purl:    purl("pypi", name, version),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pkgs, nil
+}
+
+func readPythonMetadata(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var name, version string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Name: "):
+			name = strings.TrimPrefix(line, "Name: ")
+		case strings.HasPrefix(line, "Version: "):
+			version = strings.TrimPrefix(line, "Version: ")
+		}
+		if name != "" && version != "" {
+			break
+		}
+	}
+
+	return name, version, scanner.Err()
+}
+
+// scanNode walks rootfs for node_modules/*/package.json files, skipping
+// the top-level package.json of whatever project owns node_modules.
+func scanNode(rootfs string) ([]Package, error) {
+	var pkgs []Package
+
+	var pkgJSON struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	err := walkFiles(rootfs, "package.json", func(path string) error {
+		if !strings.Contains(path, string(filepath.Separator)+"node_modules"+string(filepath.Separator)) {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		pkgJSON.Name, pkgJSON.Version = "", ""
+		if err := json.Unmarshal(data, &pkgJSON); err != nil || pkgJSON.Name == "" {
+			return nil
+		}
+
+		pkgs = append(pkgs, Package{
+			Name:    pkgJSON.Name,
+			Version: pkgJSON.Version,
+			Type:    "npm",
+			Purl:    purl("npm", pkgJSON.Name, pkgJSON.Version),
+		})
+		return nil
+	})
+
+	return pkgs, err
+}
@@ -0,0 +1,144 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// scanRPM shells out to rpm --root, rather than parsing the Berkeley DB/
+// sqlite rpmdb formats directly, since rpm itself already knows how to
+// read whichever backend the rootfs was built with.
+func scanRPM(rootfs string) ([]Package, error) {
+	if !pathExists(filepath.Join(rootfs, "var", "lib", "rpm")) {
+		return nil, nil
+	}
+
+	if _, err := exec.LookPath("rpm"); err != nil {
+		return nil, nil
+	}
+
+	out, err := exec.Command("rpm", "--root", rootfs, "-qa", "--queryformat", "%{NAME}\t%{VERSION}-%{RELEASE}\n").Output()
+	if err != nil {
+		return nil, nil
+	}
+
+	var pkgs []Package
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Name:    fields[0],
+			Version: fields[1],
+			Type:    "rpm",
+			Purl:    purl("rpm", fields[0], fields[1]),
+		})
+	}
+
+	return pkgs, scanner.Err()
+}
+
+// scanDpkg parses /var/lib/dpkg/status, dpkg's plain-text package
+// database: stanzas of "Key: value" lines separated by blank lines. Only
+// packages whose three-word "want flag status" triple ends in
+// "installed" are reported; purged/removed packages leave a stanza
+// behind with a status like "purge ok not-installed".
+func scanDpkg(rootfs string) ([]Package, error) {
+	statusPath := filepath.Join(rootfs, "var", "lib", "dpkg", "status")
+	if !pathExists(statusPath) {
+		return nil, nil
+	}
+
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pkgs []Package
+	var name, version, status string
+
+	flush := func() {
+		if name != "" && status == "installed" {
+			pkgs = append(pkgs, Package{
+				Name:    name,
+				Version: version,
+				Type:    "deb",
+				Purl:    purl("deb", name, version),
+			})
+		}
+		name, version, status = "", "", ""
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "Package: "):
+			name = strings.TrimPrefix(line, "Package: ")
+		case strings.HasPrefix(line, "Version: "):
+			version = strings.TrimPrefix(line, "Version: ")
+		case strings.HasPrefix(line, "Status: "):
+			status = strings.TrimPrefix(line, "Status: ")
+			status = status[strings.LastIndex(status, " ")+1:]
+		}
+	}
+	flush()
+
+	return pkgs, scanner.Err()
+}
+
+// scanApk parses /lib/apk/db/installed, apk's plain-text package database:
+// stanzas of single-letter-prefixed lines ("P:" name, "V:" version)
+// separated by blank lines.
+func scanApk(rootfs string) ([]Package, error) {
+	installedPath := filepath.Join(rootfs, "lib", "apk", "db", "installed")
+	if !pathExists(installedPath) {
+		return nil, nil
+	}
+
+	f, err := os.Open(installedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pkgs []Package
+	var name, version string
+
+	flush := func() {
+		if name != "" {
+			pkgs = append(pkgs, Package{
+				Name:    name,
+				Version: version,
+				Type:    "apk",
+				Purl:    purl("apk", name, version),
+			})
+		}
+		name, version = "", ""
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "P:"):
+			name = strings.TrimPrefix(line, "P:")
+		case strings.HasPrefix(line, "V:"):
+			version = strings.TrimPrefix(line, "V:")
+		}
+	}
+	flush()
+
+	return pkgs, scanner.Err()
+}
@@ -0,0 +1,79 @@
+package sbom
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/openSUSE/umoci"
+)
+
+// Print implements `stacker sbom <tag>`: it looks up the SPDX and
+// CycloneDX documents Attach stored for tag (under tag+"-spdx" and
+// tag+"-cyclonedx") and writes each document's raw JSON to w, in turn.
+func Print(layout *umoci.Layout, tag string, w io.Writer) error {
+	for _, format := range []string{MediaTypeSPDX, MediaTypeCycloneDX} {
+		doc, err := readDocument(layout, tag, format)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(doc); err != nil {
+			return errors.Wrapf(err, "write %s document", format)
+		}
+	}
+
+	return nil
+}
+
+// Extract writes each SBOM document attached to tag out to its own file,
+// named dir/<tag>.spdx.json and dir/<tag>.cyclonedx.json.
+func Extract(layout *umoci.Layout, tag string, dir string) error {
+	for format, suffix := range map[string]string{
+		MediaTypeSPDX:      ".spdx.json",
+		MediaTypeCycloneDX: ".cyclonedx.json",
+	} {
+		doc, err := readDocument(layout, tag, format)
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(dir, tag+suffix, doc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readDocument(layout *umoci.Layout, tag string, mediaType string) ([]byte, error) {
+	artifactTag := tag + sbomTagSuffix(mediaType)
+
+	manifest, err := layout.LookupManifest(artifactTag)
+	if err != nil {
+		return nil, errors.Wrapf(err, "no %s SBOM attached to %s", mediaType, tag)
+	}
+
+	if len(manifest.Layers) != 1 {
+		return nil, errors.Errorf("malformed SBOM artifact manifest %s: expected exactly one layer", artifactTag)
+	}
+
+	return layout.ReadBlob(manifest.Layers[0])
+}
+
+func sbomTagSuffix(mediaType string) string {
+	if mediaType == MediaTypeSPDX {
+		return "-spdx"
+	}
+	return "-cyclonedx"
+}
+
+func writeFile(dir string, name string, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, name), data, 0644)
+}
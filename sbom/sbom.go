@@ -0,0 +1,132 @@
+// Package sbom scans a built layer's rootfs for installed packages and
+// renders the result as an SPDX-JSON or CycloneDX-JSON software bill of
+// materials. It's driven by a layer's `generate_sbom: true` directive (or
+// the global `--sbom` build flag); see Attach for how the resulting
+// documents get attached to the built OCI image.
+package sbom
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Package describes a single software package discovered on a rootfs, in
+// the common shape both the SPDX and CycloneDX builders consume.
+type Package struct {
+	// Name is the package's name within its ecosystem.
+	Name string
+	// Version is the package's version string, as reported by its
+	// ecosystem; formats vary (semver, rpm EVR, etc) and are not
+	// normalized here.
+	Version string
+	// Type is the package ecosystem: "rpm", "deb", "apk", "golang",
+	// "python", or "npm".
+	Type string
+	// Purl is this package's Package URL
+	// (https://github.com/package-url/purl-spec), used as the primary
+	// cross-reference in both SBOM formats.
+	Purl string
+}
+
+// Scan walks rootfs and returns every package its supported scanners
+// recognize: rpm/dpkg/apk package databases, Go binaries' embedded module
+// info, Python dist-info metadata, and node_modules package.json files.
+// A scanner that finds nothing (e.g. no rpm database on a Debian rootfs)
+// is silently skipped; only unexpected I/O errors are returned.
+func Scan(rootfs string) ([]Package, error) {
+	var pkgs []Package
+
+	for _, scan := range []func(string) ([]Package, error){
+		scanRPM,
+		scanDpkg,
+		scanApk,
+		scanGoBinaries,
+		scanPython,
+		scanNode,
+	} {
+		found, err := scan(rootfs)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, found...)
+	}
+
+	return dedup(pkgs), nil
+}
+
+// ScanDiff returns only the packages present under childRootfs that
+// weren't already present under parentRootfs, so a layer's SBOM can
+// describe just the packages it introduced rather than its whole image.
+// parentRootfs may be "", in which case it behaves like Scan(childRootfs).
+func ScanDiff(parentRootfs string, childRootfs string) ([]Package, error) {
+	child, err := Scan(childRootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentRootfs == "" {
+		return child, nil
+	}
+
+	parent, err := Scan(parentRootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, p := range parent {
+		seen[p.Purl] = true
+	}
+
+	var added []Package
+	for _, p := range child {
+		if !seen[p.Purl] {
+			added = append(added, p)
+		}
+	}
+
+	return added, nil
+}
+
+func dedup(pkgs []Package) []Package {
+	seen := map[string]bool{}
+	out := make([]Package, 0, len(pkgs))
+	for _, p := range pkgs {
+		if seen[p.Purl] {
+			continue
+		}
+		seen[p.Purl] = true
+		out = append(out, p)
+	}
+	return out
+}
+
+// pathExists is a small helper so scanners can bail out early (returning
+// no packages, no error) when their database/directory isn't present.
+func pathExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// walkFiles calls fn for every regular file under dir matching name,
+// ignoring the "directory doesn't exist" case scanners treat as "nothing
+// to report".
+func walkFiles(dir string, name string, fn func(path string) error) error {
+	if !pathExists(dir) {
+		return nil
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != name {
+			return nil
+		}
+		return fn(path)
+	})
+}
+
+func purl(ecosystem string, name string, version string) string {
+	return "pkg:" + ecosystem + "/" + name + "@" + version
+}
@@ -0,0 +1,67 @@
+package stacker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/anuvu/stacker/types"
+	"github.com/openSUSE/umoci"
+)
+
+func TestMaybeAttachSbomSkipsWhenNotRequested(t *testing.T) {
+	l := &types.Layer{}
+
+	// oci is nil: if this somehow tried to attach anything, it would
+	// panic, so a clean return proves the no-op path was taken.
+	if err := MaybeAttachSbom(nil, l, "tag", ispec.Descriptor{}, "", ""); err != nil {
+		t.Fatalf("expected no-op for a layer without generate_sbom, got: %v", err)
+	}
+}
+
+func TestMaybeAttachSbomAttachesAndSbomPrints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_sbom_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	layoutPath := path.Join(dir, "oci")
+	oci, err := umoci.CreateLayout(layoutPath)
+	if err != nil {
+		t.Fatalf("couldn't create OCI layout: %v", err)
+	}
+
+	rootfs := path.Join(dir, "rootfs")
+	dpkgDir := path.Join(rootfs, "var", "lib", "dpkg")
+	if err := os.MkdirAll(dpkgDir, 0755); err != nil {
+		t.Fatalf("couldn't create dpkg dir: %v", err)
+	}
+	status := "Package: foo\nStatus: install ok installed\nVersion: 1.0\n"
+	if err := os.WriteFile(path.Join(dpkgDir, "status"), []byte(status), 0644); err != nil {
+		t.Fatalf("couldn't write dpkg status: %v", err)
+	}
+
+	l := &types.Layer{GenerateSbom: true}
+
+	err = MaybeAttachSbom(oci, l, "myimage", ispec.Descriptor{}, "", rootfs)
+	if err != nil {
+		t.Fatalf("MaybeAttachSbom: %v", err)
+	}
+	if err := oci.Close(); err != nil {
+		t.Fatalf("couldn't close layout: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Sbom(layoutPath, "myimage", &out); err != nil {
+		t.Fatalf("Sbom: %v", err)
+	}
+
+	if !bytes.Contains(out.Bytes(), []byte(`"name": "foo"`)) {
+		t.Errorf("expected the attached SBOM to mention package foo, got:\n%s", out.String())
+	}
+}
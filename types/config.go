@@ -0,0 +1,17 @@
+package types
+
+// StackerConfig holds the build-wide settings threaded through a stacker
+// invocation - as opposed to Layer, which holds settings for a single
+// layer in a Stackerfile.
+type StackerConfig struct {
+	// StackerDir is stacker's working directory, used to cache
+	// downloaded artifacts (e.g. sigstore roots) across invocations.
+	StackerDir string
+	// RootFSDir is where layer rootfses are built and stored.
+	RootFSDir string
+
+	// LayerCompression is the default compression ("gzip", "zstd", or
+	// "zstd:chunked") used for any layer that doesn't set its own
+	// `compression:` directive. Defaults to "gzip" when empty.
+	LayerCompression string
+}
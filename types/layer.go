@@ -14,10 +14,12 @@ import (
 )
 
 const (
-	DockerLayer = "docker"
-	TarLayer    = "tar"
-	OCILayer    = "oci"
-	BuiltLayer  = "built"
+	DockerLayer     = "docker"
+	TarLayer        = "tar"
+	OCILayer        = "oci"
+	BuiltLayer      = "built"
+	ContainerdLayer = "containerd"
+	CRILayer        = "cri"
 )
 
 func IsContainersImageLayer(from string) bool {
@@ -31,13 +33,103 @@ func IsContainersImageLayer(from string) bool {
 	return false
 }
 
+// ImageSource describes where a layer's `from:` base image comes from.
+type ImageSource struct {
+	Type string `yaml:"type"`
+
+	// Url is the location of the base image; its meaning depends on
+	// Type (a docker:// reference, a path to a tar file or OCI layout,
+	// or a containerd/CRI runtime socket).
+	Url string `yaml:"url"`
+
+	Tag string `yaml:"tag"`
+
+	// Namespace is the containerd namespace to pull/push the image
+	// to/from. Only meaningful when Type is ContainerdLayer.
+	Namespace string `yaml:"namespace"`
+
+	// Ref is the image reference to pull/push in the remote runtime's
+	// image store. Only meaningful when Type is ContainerdLayer or
+	// CRILayer.
+	Ref string `yaml:"ref"`
+}
+
+func IsRuntimeLayer(from string) bool {
+	switch from {
+	case ContainerdLayer:
+		return true
+	case CRILayer:
+		return true
+	}
+
+	return false
+}
+
 type ImportMap struct {
 	Path string
 	Hash string
+
+	// Signature verification. Sigstore is the detached signature; it is
+	// checked against Cert/Identity for keyless (Fulcio/Rekor)
+	// verification or, when Cert is empty, against CosignKey (a plain
+	// ECDSA/RSA public key) for keyed verification. Without Sigstore no
+	// signature check is made, even if Cert or CosignKey is set.
+	Sigstore  string
+	Cert      string
+	Identity  string
+	CosignKey string
 }
 
 type ImportMaps []ImportMap
 
+// EncryptionConfig declares the recipients that a layer's output should be
+// encrypted for, accepted either as a bare list of recipient public keys or
+// as a map with an explicit algorithm.
+type EncryptionConfig struct {
+	Recipients []string
+	Algorithm  string
+}
+
+// Custom Unmarshal from a list of recipients, or a map of
+// {recipients, algorithm}, into an EncryptionConfig.
+func (e *EncryptionConfig) UnmarshalJSON(b []byte) error {
+	var data interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	switch v := data.(type) {
+	case []interface{}:
+		for _, r := range v {
+			e.Recipients = append(e.Recipients, fmt.Sprintf("%v", r))
+		}
+	case map[interface{}]interface{}:
+		for _, r := range toInterfaceSlice(v["recipients"]) {
+			e.Recipients = append(e.Recipients, fmt.Sprintf("%v", r))
+		}
+		e.Algorithm, _ = v["algorithm"].(string)
+	case map[string]interface{}:
+		for _, r := range toInterfaceSlice(v["recipients"]) {
+			e.Recipients = append(e.Recipients, fmt.Sprintf("%v", r))
+		}
+		e.Algorithm, _ = v["algorithm"].(string)
+	case nil:
+		// no encrypt: directive given
+	default:
+		return errors.Errorf("unknown encrypt directive type: %T", data)
+	}
+
+	return nil
+}
+
+func toInterfaceSlice(v interface{}) []interface{} {
+	s, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	return s
+}
+
 type Layer struct {
 	From               *ImageSource      `yaml:"from"`
 	Import             ImportMaps        `yaml:"import"`
@@ -55,17 +147,50 @@ type Layer struct {
 	BuildOnly          bool              `yaml:"build_only"`
 	Binds              interface{}       `yaml:"binds"`
 	RuntimeUser        string            `yaml:"runtime_user"`
+	Encrypt            *EncryptionConfig `yaml:"encrypt"`
+	GenerateSbom       bool              `yaml:"generate_sbom"`
+	Compression        string            `yaml:"compression"`
 	referenceDirectory string            // Location of the directory where the layer is defined
 }
 
+func stringOrEmpty(m map[interface{}]interface{}, key string) string {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func stringOrEmpty2(m map[string]interface{}, key string) string {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
 func getImportMapFromInterface(v interface{}) ImportMap {
 	m, ok := v.(map[interface{}]interface{})
 	if ok {
-		return ImportMap{Hash: fmt.Sprintf("%v", m["hash"]), Path: fmt.Sprintf("%v", m["path"])}
+		return ImportMap{
+			Hash:      stringOrEmpty(m, "hash"),
+			Path:      stringOrEmpty(m, "path"),
+			Sigstore:  stringOrEmpty(m, "sigstore"),
+			Cert:      stringOrEmpty(m, "cert"),
+			Identity:  stringOrEmpty(m, "identity"),
+			CosignKey: stringOrEmpty(m, "cosign_key"),
+		}
 	}
 	m2, ok := v.(map[string]interface{})
 	if ok {
-		return ImportMap{Hash: fmt.Sprintf("%v", m2["hash"]), Path: fmt.Sprintf("%v", m2["path"])}
+		return ImportMap{
+			Hash:      stringOrEmpty2(m2, "hash"),
+			Path:      stringOrEmpty2(m2, "path"),
+			Sigstore:  stringOrEmpty2(m2, "sigstore"),
+			Cert:      stringOrEmpty2(m2, "cert"),
+			Identity:  stringOrEmpty2(m2, "identity"),
+			CosignKey: stringOrEmpty2(m2, "cosign_key"),
+		}
 	}
 	// if it's not a map then it's a string
 	s, ok := v.(string)
@@ -173,6 +298,17 @@ func (l *Layer) ParseFullCommand() ([]string, error) {
 	})
 }
 
+// getAbsPathIfSet resolves path, unless it is empty, in which case it is
+// returned unchanged. This is used for the optional local-file fields of an
+// import (e.g. detached signatures and certs) that shouldn't be forced to
+// resolve to a path when the user hasn't set them.
+func (l *Layer) getAbsPathIfSet(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	return l.getAbsPath(path)
+}
+
 func (l *Layer) ParseImport() (ImportMaps, error) {
 	var absImports ImportMaps
 	var absImport ImportMap
@@ -181,7 +317,26 @@ func (l *Layer) ParseImport() (ImportMaps, error) {
 		if err != nil {
 			return nil, err
 		}
-		absImport = ImportMap{Hash: rawImport.Hash, Path: absImportPath}
+		sigstorePath, err := l.getAbsPathIfSet(rawImport.Sigstore)
+		if err != nil {
+			return nil, err
+		}
+		certPath, err := l.getAbsPathIfSet(rawImport.Cert)
+		if err != nil {
+			return nil, err
+		}
+		cosignKeyPath, err := l.getAbsPathIfSet(rawImport.CosignKey)
+		if err != nil {
+			return nil, err
+		}
+		absImport = ImportMap{
+			Hash:      rawImport.Hash,
+			Path:      absImportPath,
+			Sigstore:  sigstorePath,
+			Cert:      certPath,
+			Identity:  rawImport.Identity,
+			CosignKey: cosignKeyPath,
+		}
 		absImports = append(absImports, absImport)
 	}
 	return absImports, nil
@@ -233,6 +388,47 @@ func (l *Layer) ParseGenerateLabels() ([]string, error) {
 	})
 }
 
+// ParseEncrypt returns this layer's EncryptionConfig, defaulting Algorithm
+// to "aes-256-ctr" when it wasn't set. It returns nil if the layer isn't
+// encrypted.
+func (l *Layer) ParseEncrypt() (*EncryptionConfig, error) {
+	if l.Encrypt == nil {
+		return nil, nil
+	}
+
+	if len(l.Encrypt.Recipients) == 0 {
+		return nil, errors.Errorf("encrypt given with no recipients")
+	}
+
+	enc := *l.Encrypt
+	if enc.Algorithm == "" {
+		enc.Algorithm = "aes-256-ctr"
+	}
+
+	return &enc, nil
+}
+
+// ParseCompression returns this layer's requested compression ("gzip",
+// "zstd", or "zstd:chunked"): the layer's own `compression:` directive if
+// it set one, else sc.LayerCompression, else "gzip". Errors if whichever
+// of those wins isn't one of the three recognized values.
+func (l *Layer) ParseCompression(sc StackerConfig) (string, error) {
+	compression := l.Compression
+	if compression == "" {
+		compression = sc.LayerCompression
+	}
+	if compression == "" {
+		return "gzip", nil
+	}
+
+	switch compression {
+	case "gzip", "zstd", "zstd:chunked":
+		return compression, nil
+	default:
+		return "", errors.Errorf("unknown compression %q", compression)
+	}
+}
+
 func (l *Layer) getAbsPath(path string) (string, error) {
 	parsedPath, err := NewDockerishUrl(path)
 	if err != nil {
@@ -296,8 +492,12 @@ func interfaceToMapString(v interface{}) (map[string]interface{}, error) {
 	m, ok := v.(map[interface{}]interface{})
 	if ok {
 		return map[string]interface{}{
-			"path": fmt.Sprintf("%v", m["path"]),
-			"hash": fmt.Sprintf("%v", m["hash"]),
+			"path":       fmt.Sprintf("%v", m["path"]),
+			"hash":       fmt.Sprintf("%v", m["hash"]),
+			"sigstore":   stringOrEmpty(m, "sigstore"),
+			"cert":       stringOrEmpty(m, "cert"),
+			"identity":   stringOrEmpty(m, "identity"),
+			"cosign_key": stringOrEmpty(m, "cosign_key"),
 		}, nil
 	}
 
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncryptionConfigUnmarshalMapWithoutAlgorithm(t *testing.T) {
+	var e EncryptionConfig
+	if err := json.Unmarshal([]byte(`{"recipients": ["key1"]}`), &e); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if e.Algorithm != "" {
+		t.Errorf("algorithm = %q, want empty so ParseEncrypt can default it", e.Algorithm)
+	}
+	if len(e.Recipients) != 1 || e.Recipients[0] != "key1" {
+		t.Errorf("recipients = %v, want [key1]", e.Recipients)
+	}
+}
+
+func TestEncryptionConfigUnmarshalMapWithAlgorithm(t *testing.T) {
+	var e EncryptionConfig
+	if err := json.Unmarshal([]byte(`{"recipients": ["key1"], "algorithm": "aes-256-gcm"}`), &e); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if e.Algorithm != "aes-256-gcm" {
+		t.Errorf("algorithm = %q, want aes-256-gcm", e.Algorithm)
+	}
+}
+
+func TestEncryptionConfigUnmarshalBareList(t *testing.T) {
+	var e EncryptionConfig
+	if err := json.Unmarshal([]byte(`["key1", "key2"]`), &e); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if e.Algorithm != "" {
+		t.Errorf("algorithm = %q, want empty", e.Algorithm)
+	}
+	if len(e.Recipients) != 2 {
+		t.Errorf("recipients = %v, want 2 entries", e.Recipients)
+	}
+}
+
+func TestParseEncryptDefaultsAlgorithmFromMapForm(t *testing.T) {
+	l := &Layer{}
+	if err := json.Unmarshal([]byte(`{"recipients": ["key1"]}`), &l.Encrypt); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	enc, err := l.ParseEncrypt()
+	if err != nil {
+		t.Fatalf("ParseEncrypt: %v", err)
+	}
+	if enc.Algorithm != "aes-256-ctr" {
+		t.Errorf("algorithm = %q, want aes-256-ctr", enc.Algorithm)
+	}
+}
+
+func TestParseCompressionFallsBackToStackerConfig(t *testing.T) {
+	sc := StackerConfig{LayerCompression: "zstd:chunked"}
+
+	got, err := (&Layer{}).ParseCompression(sc)
+	if err != nil {
+		t.Fatalf("ParseCompression: %v", err)
+	}
+	if got != "zstd:chunked" {
+		t.Errorf("compression = %q, want sc.LayerCompression's zstd:chunked", got)
+	}
+
+	got, err = (&Layer{Compression: "gzip"}).ParseCompression(sc)
+	if err != nil {
+		t.Fatalf("ParseCompression: %v", err)
+	}
+	if got != "gzip" {
+		t.Errorf("compression = %q, want the layer's own gzip to win over sc.LayerCompression", got)
+	}
+}
+
+func TestParseCompressionDefaultsToGzip(t *testing.T) {
+	got, err := (&Layer{}).ParseCompression(StackerConfig{})
+	if err != nil {
+		t.Fatalf("ParseCompression: %v", err)
+	}
+	if got != "gzip" {
+		t.Errorf("compression = %q, want gzip", got)
+	}
+}
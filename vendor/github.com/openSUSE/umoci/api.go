@@ -1,14 +1,16 @@
 package umoci
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 
 	"github.com/openSUSE/umoci/oci/cas"
 	"github.com/openSUSE/umoci/oci/cas/dir"
 	"github.com/openSUSE/umoci/oci/casext"
-	"github.com/openSUSE/umoci/oci/layer"
 	igen "github.com/openSUSE/umoci/oci/config/generate"
+	"github.com/openSUSE/umoci/oci/layer"
 	"github.com/opencontainers/go-digest"
 	imeta "github.com/opencontainers/image-spec/specs-go"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -96,10 +98,37 @@ func (l *Layout) PutBlob(b io.Reader) (Blob, error) {
 	return Blob{Hash: string(digest), Size: size}, nil
 }
 
-// Blob describes a blob that has been added to the OCI image.
+// ReadBlob reads back the raw contents of a descriptor already present in
+// the CAS, for callers (like runtimeimage) that need to copy a blob
+// somewhere else without re-deriving its bytes.
+func (l *Layout) ReadBlob(desc ispec.Descriptor) ([]byte, error) {
+	return l.readBlob(desc)
+}
+
+// readBlob is the internal implementation behind ReadBlob, also used by
+// layer decryption below.
+func (l *Layout) readBlob(desc ispec.Descriptor) ([]byte, error) {
+	blob, err := l.ext.FromDescriptor(context.Background(), desc)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	r, ok := blob.Data.(io.Reader)
+	if !ok {
+		return nil, errors.Errorf("blob %s is not a raw reader", desc.Digest)
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+// Blob describes a blob that has been added to the OCI image. Annotations,
+// when set, are carried over onto the layer descriptor that references this
+// blob (e.g. the zstd:chunked table-of-contents annotations).
 type Blob struct {
-	Hash string
-	Size int64
+	Hash        string
+	Size        int64
+	Annotations map[string]string
 }
 
 // ToDigest converts this layer into an opencontainers digest
@@ -108,20 +137,68 @@ func (l Blob) ToDigest() (digest.Digest, error) {
 }
 
 // NewImage creates a new OCI manifest in the OCI image, and adds the specified
-// layers to it.
+// layers to it. If enc is non-nil, every layer is encrypted for enc's
+// recipients and its descriptor's media type gets the ocicrypt
+// "+encrypted" suffix.
 func (l *Layout) NewImage(tagName string, g *igen.Generator, layers []Blob, mediaType string) error {
+	return l.newImage(tagName, g, layers, mediaType, nil)
+}
+
+// NewEncryptedImage behaves like NewImage, but additionally encrypts each
+// layer blob for enc's recipients before it is referenced by the manifest.
+func (l *Layout) NewEncryptedImage(tagName string, g *igen.Generator, layers []Blob, mediaType string, enc *EncryptionConfig) error {
+	return l.newImage(tagName, g, layers, mediaType, enc)
+}
+
+func (l *Layout) newImage(tagName string, g *igen.Generator, layers []Blob, mediaType string, enc *EncryptionConfig) error {
 	layerDescriptors := []ispec.Descriptor{}
-	for _, l := range layers {
-		d, err := digest.Parse(l.Hash)
+	for _, blob := range layers {
+		d, err := digest.Parse(blob.Hash)
 		if err != nil {
 			return err
 		}
 
-		layerDescriptors = append(layerDescriptors, ispec.Descriptor{
-			MediaType: mediaType,
-			Digest:    d,
-			Size:      l.Size,
-		})
+		desc := ispec.Descriptor{
+			MediaType:   mediaType,
+			Digest:      d,
+			Size:        blob.Size,
+			Annotations: blob.Annotations,
+		}
+
+		if enc != nil {
+			plaintext, err := l.readBlob(desc)
+			if err != nil {
+				return errors.Wrap(err, "read layer for encryption")
+			}
+
+			encBlob, annotations, err := l.PutEncryptedBlob(bytes.NewReader(plaintext), enc)
+			if err != nil {
+				return errors.Wrap(err, "encrypt layer")
+			}
+
+			// The plaintext blob was only ever needed to produce encBlob
+			// above; leaving it sitting in blobs/sha256 right next to the
+			// ciphertext it was encrypted into would defeat the point of
+			// encrypt: for anyone reading the layout directly, so purge it
+			// now that the manifest will only reference the ciphertext.
+			if err := l.engine.DeleteBlob(context.Background(), d); err != nil {
+				return errors.Wrap(err, "purge plaintext layer after encryption")
+			}
+
+			encDigest, err := digest.Parse(encBlob.Hash)
+			if err != nil {
+				return err
+			}
+
+			desc = ispec.Descriptor{
+				MediaType:   encryptedMediaType(mediaType),
+				Digest:      encDigest,
+				Size:        encBlob.Size,
+				Annotations: annotations,
+			}
+		}
+
+		layerDescriptors = append(layerDescriptors, desc)
 	}
 
 	// Update config and create a new blob for it.
@@ -164,6 +241,79 @@ func (l *Layout) NewImage(tagName string, g *igen.Generator, layers []Blob, medi
 	return nil
 }
 
+// PutManifest stores manifest as a blob and tags it tagName, without
+// touching its config or layer descriptors. It's used by callers (like
+// runtimeimage) that already have a complete manifest referencing blobs
+// they've copied in themselves via PutBlob, as opposed to NewImage's
+// from-scratch config generation.
+func (l *Layout) PutManifest(manifest ispec.Manifest, tagName string) error {
+	manifestDigest, manifestSize, err := l.ext.PutBlobJSON(context.Background(), manifest)
+	if err != nil {
+		return errors.Wrap(err, "put manifest blob")
+	}
+
+	descriptor := ispec.Descriptor{
+		MediaType: ispec.MediaTypeImageManifest,
+		Digest:    manifestDigest,
+		Size:      manifestSize,
+	}
+
+	return errors.Wrap(l.ext.UpdateReference(context.Background(), tagName, descriptor), "add new tag")
+}
+
+// emptyConfigMediaType is the media type the OCI 1.1 image spec reserves
+// for an artifact manifest's config, when the artifact has no meaningful
+// config of its own (the manifest's own artifactType carries that
+// information instead).
+const emptyConfigMediaType = "application/vnd.oci.empty.v1+json"
+
+// PutArtifactManifest stores blobs (already added via PutBlob) as the
+// layers of an OCI 1.1 artifact manifest: artifactType describes what the
+// blobs are (e.g. "application/spdx+json"), subject is the descriptor of
+// the image manifest this artifact is *about*, and annotations are set on
+// the manifest itself. The resulting manifest is tagged tagName so it can
+// be looked up directly in addition to being discoverable via the OCI
+// distribution referrers API.
+func (l *Layout) PutArtifactManifest(artifactType string, blobs []Blob, subject ispec.Descriptor, annotations map[string]string, tagName string) error {
+	emptyConfig, err := l.PutBlob(bytes.NewReader([]byte("{}")))
+	if err != nil {
+		return errors.Wrap(err, "put empty config")
+	}
+
+	emptyConfigDigest, err := emptyConfig.ToDigest()
+	if err != nil {
+		return err
+	}
+
+	layers := make([]ispec.Descriptor, 0, len(blobs))
+	for _, b := range blobs {
+		d, err := b.ToDigest()
+		if err != nil {
+			return err
+		}
+		layers = append(layers, ispec.Descriptor{
+			MediaType: artifactType,
+			Digest:    d,
+			Size:      b.Size,
+		})
+	}
+
+	manifest := ispec.Manifest{
+		Versioned:    imeta.Versioned{SchemaVersion: 2},
+		ArtifactType: artifactType,
+		Config: ispec.Descriptor{
+			MediaType: emptyConfigMediaType,
+			Digest:    emptyConfigDigest,
+			Size:      emptyConfig.Size,
+		},
+		Layers:      layers,
+		Subject:     &subject,
+		Annotations: annotations,
+	}
+
+	return l.PutManifest(manifest, tagName)
+}
+
 // ListTags lists the tags in the OCI image.
 func (l *Layout) ListTags() ([]string, error) {
 	return l.ext.ListReferences(context.Background())
@@ -227,8 +377,8 @@ func (l *Layout) LookupConfig(b Blob) (ispec.Image, error) {
 
 	desc := ispec.Descriptor{
 		MediaType: ispec.MediaTypeImageConfig,
-		Digest: d,
-		Size:   b.Size,
+		Digest:    d,
+		Size:      b.Size,
 	}
 
 	config, err := l.ext.FromDescriptor(context.Background(), desc)
@@ -249,10 +399,27 @@ func (l *Layout) LookupConfig(b Blob) (ispec.Image, error) {
 }
 
 func (l *Layout) Unpack(tag string, path string, mo *layer.MapOptions) error {
+	return l.unpack(tag, path, mo, nil)
+}
+
+// UnpackEncrypted behaves like Unpack, but first decrypts any layers that
+// were encrypted with NewEncryptedImage, using dc's private keys.
+func (l *Layout) UnpackEncrypted(tag string, path string, mo *layer.MapOptions, dc *DecryptionConfig) error {
+	return l.unpack(tag, path, mo, dc)
+}
+
+func (l *Layout) unpack(tag string, path string, mo *layer.MapOptions, dc *DecryptionConfig) error {
 	manifest, err := l.LookupManifest(tag)
 	if err != nil {
 		return err
 	}
 
+	if dc != nil {
+		manifest, err = l.decryptManifestLayers(manifest, dc)
+		if err != nil {
+			return errors.Wrap(err, "decrypt layers")
+		}
+	}
+
 	return layer.UnpackManifest(context.Background(), l.ext, path, manifest, mo)
-}
\ No newline at end of file
+}
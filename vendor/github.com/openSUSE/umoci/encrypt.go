@@ -0,0 +1,358 @@
+package umoci
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mediaTypeSuffixEncrypted is appended to a layer's usual media type
+	// once it has been wrapped with ocicrypt.
+	mediaTypeSuffixEncrypted = "+encrypted"
+
+	annotationKeysJWE   = "org.opencontainers.image.enc.keys.jwe"
+	annotationKeysPGP   = "org.opencontainers.image.enc.keys.pgp"
+	annotationKeysPKCS7 = "org.opencontainers.image.enc.keys.pkcs7"
+	annotationPubOpts   = "org.opencontainers.image.enc.pubopts"
+
+	// AlgorithmAESCTR and AlgorithmAESGCM are the symmetric ciphers
+	// encryptBlob/decryptBlob know how to speak; any other value is
+	// rejected rather than silently treated as one of these.
+	AlgorithmAESCTR = "aes-256-ctr"
+	AlgorithmAESGCM = "aes-256-gcm"
+)
+
+// RecipientType identifies the keywrap protocol used to protect a DEK for a
+// particular recipient.
+type RecipientType string
+
+const (
+	RecipientPGP   RecipientType = "pgp"
+	RecipientJWE   RecipientType = "jwe"
+	RecipientPKCS7 RecipientType = "pkcs7"
+)
+
+// Recipient is a single key that a layer's DEK should be wrapped for.
+type Recipient struct {
+	Type RecipientType
+	// Key is the recipient's public key material, in whatever form the
+	// keywrap implementation expects (e.g. an armored PGP public key, a
+	// JWK, or a PEM certificate for PKCS7).
+	Key []byte
+}
+
+// EncryptionConfig describes how layer blobs should be encrypted before
+// being added to an OCI image.
+type EncryptionConfig struct {
+	Recipients []Recipient
+	// Algorithm is the symmetric cipher used to protect the layer
+	// content itself; one of "aes-256-ctr" (default) or "aes-256-gcm".
+	Algorithm string
+}
+
+// DecryptionConfig describes the private keys available to decrypt layers
+// produced with EncryptionConfig.
+type DecryptionConfig struct {
+	// PrivateKeys are PEM/armored private key files, one per supported
+	// keywrap protocol that the caller is prepared to unwrap.
+	PrivateKeys [][]byte
+}
+
+func defaultAlgorithm(alg string) string {
+	if alg == "" {
+		return AlgorithmAESCTR
+	}
+	return alg
+}
+
+// pubOpts is the metadata ocicrypt expects alongside the wrapped keys, so
+// that a decrypting party knows how the blob itself was encrypted.
+type pubOpts struct {
+	Cipher       string            `json:"cipher"`
+	Hint         string            `json:"hint,omitempty"`
+	CipherOption map[string]string `json:"cipheroptions,omitempty"`
+}
+
+// encryptBlob symmetrically encrypts cleartext with a freshly generated DEK
+// under algorithm (AlgorithmAESCTR or AlgorithmAESGCM) and returns the
+// ciphertext, the DEK, and the IV/nonce used.
+func encryptBlob(cleartext []byte, algorithm string) (ciphertext []byte, dek []byte, iv []byte, err error) {
+	dek = make([]byte, 32) // AES-256
+	if _, err = rand.Read(dek); err != nil {
+		return nil, nil, nil, err
+	}
+
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	switch algorithm {
+	case AlgorithmAESCTR:
+		iv = make([]byte, aes.BlockSize)
+		if _, err = rand.Read(iv); err != nil {
+			return nil, nil, nil, err
+		}
+
+		ciphertext = make([]byte, len(cleartext))
+		cipher.NewCTR(block, iv).XORKeyStream(ciphertext, cleartext)
+
+	case AlgorithmAESGCM:
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		iv = make([]byte, gcm.NonceSize())
+		if _, err = rand.Read(iv); err != nil {
+			return nil, nil, nil, err
+		}
+
+		ciphertext = gcm.Seal(nil, iv, cleartext, nil)
+
+	default:
+		return nil, nil, nil, errors.Errorf("unsupported encryption algorithm %q", algorithm)
+	}
+
+	return ciphertext, dek, iv, nil
+}
+
+// decryptBlob reverses encryptBlob; algorithm must match the one the blob
+// was actually encrypted with, as recorded in the layer's pubOpts.Cipher.
+func decryptBlob(ciphertext []byte, dek []byte, iv []byte, algorithm string) ([]byte, error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case AlgorithmAESCTR:
+		cleartext := make([]byte, len(ciphertext))
+		cipher.NewCTR(block, iv).XORKeyStream(cleartext, ciphertext)
+		return cleartext, nil
+
+	case AlgorithmAESGCM:
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		return gcm.Open(nil, iv, ciphertext, nil)
+
+	default:
+		return nil, errors.Errorf("unsupported encryption algorithm %q", algorithm)
+	}
+}
+
+// wrapDEK wraps dek for every recipient, grouping the resulting wrapped
+// keys by keywrap protocol, for use as descriptor annotations.
+func wrapDEK(dek []byte, recipients []Recipient) (map[string]string, error) {
+	wrapped := map[string][]string{}
+
+	for _, r := range recipients {
+		var blob []byte
+		var err error
+
+		switch r.Type {
+		case RecipientPGP:
+			blob, err = wrapPGP(dek, r.Key)
+		case RecipientJWE:
+			blob, err = wrapJWE(dek, r.Key)
+		case RecipientPKCS7:
+			blob, err = wrapPKCS7(dek, r.Key)
+		default:
+			err = errors.Errorf("unknown recipient type %q", r.Type)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "wrap DEK for %s recipient", r.Type)
+		}
+
+		wrapped[string(r.Type)] = append(wrapped[string(r.Type)], base64.StdEncoding.EncodeToString(blob))
+	}
+
+	annotations := map[string]string{}
+	for kind, blobs := range wrapped {
+		joined, err := json.Marshal(blobs)
+		if err != nil {
+			return nil, err
+		}
+
+		switch RecipientType(kind) {
+		case RecipientPGP:
+			annotations[annotationKeysPGP] = string(joined)
+		case RecipientJWE:
+			annotations[annotationKeysJWE] = string(joined)
+		case RecipientPKCS7:
+			annotations[annotationKeysPKCS7] = string(joined)
+		}
+	}
+
+	return annotations, nil
+}
+
+// unwrapDEK tries every available private key against the recipient
+// annotations on a descriptor until one successfully unwraps the DEK.
+func unwrapDEK(annotations map[string]string, dc *DecryptionConfig) ([]byte, error) {
+	tryAll := func(wrappedKeys []string, unwrap func([]byte, []byte) ([]byte, error)) ([]byte, error) {
+		for _, wk := range wrappedKeys {
+			blob, err := base64.StdEncoding.DecodeString(wk)
+			if err != nil {
+				continue
+			}
+			for _, pk := range dc.PrivateKeys {
+				if dek, err := unwrap(blob, pk); err == nil {
+					return dek, nil
+				}
+			}
+		}
+		return nil, errors.Errorf("no private key could unwrap the layer DEK")
+	}
+
+	if raw, ok := annotations[annotationKeysJWE]; ok {
+		var wrappedKeys []string
+		if err := json.Unmarshal([]byte(raw), &wrappedKeys); err != nil {
+			return nil, err
+		}
+		if dek, err := tryAll(wrappedKeys, unwrapJWE); err == nil {
+			return dek, nil
+		}
+	}
+
+	if raw, ok := annotations[annotationKeysPGP]; ok {
+		var wrappedKeys []string
+		if err := json.Unmarshal([]byte(raw), &wrappedKeys); err != nil {
+			return nil, err
+		}
+		if dek, err := tryAll(wrappedKeys, unwrapPGP); err == nil {
+			return dek, nil
+		}
+	}
+
+	if raw, ok := annotations[annotationKeysPKCS7]; ok {
+		var wrappedKeys []string
+		if err := json.Unmarshal([]byte(raw), &wrappedKeys); err != nil {
+			return nil, err
+		}
+		if dek, err := tryAll(wrappedKeys, unwrapPKCS7); err == nil {
+			return dek, nil
+		}
+	}
+
+	return nil, errors.Errorf("layer has no recipient this decryption config can satisfy")
+}
+
+// PutEncryptedBlob encrypts cleartext for the given recipients and adds the
+// resulting ciphertext blob to the CAS, returning the Blob and the
+// descriptor annotations that must be attached alongside it.
+func (l *Layout) PutEncryptedBlob(cleartext io.Reader, enc *EncryptionConfig) (Blob, map[string]string, error) {
+	raw, err := ioutil.ReadAll(cleartext)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	algorithm := defaultAlgorithm(enc.Algorithm)
+	ciphertext, dek, iv, err := encryptBlob(raw, algorithm)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	blob, err := l.PutBlob(bytes.NewReader(ciphertext))
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	annotations, err := wrapDEK(dek, enc.Recipients)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	opts := pubOpts{
+		Cipher: algorithm,
+		Hint:   fmt.Sprintf("iv=%s", base64.StdEncoding.EncodeToString(iv)),
+	}
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+	annotations[annotationPubOpts] = base64.StdEncoding.EncodeToString(optsJSON)
+
+	return blob, annotations, nil
+}
+
+// encryptedMediaType returns mediaType with the ocicrypt "+encrypted"
+// suffix, as used for encrypted layer descriptors.
+func encryptedMediaType(mediaType string) string {
+	return mediaType + mediaTypeSuffixEncrypted
+}
+
+// decryptManifestLayers returns a copy of manifest whose encrypted layers
+// have been decrypted and re-added to the CAS as plaintext blobs, so that
+// the usual unpack path can consume it unmodified.
+func (l *Layout) decryptManifestLayers(manifest ispec.Manifest, dc *DecryptionConfig) (ispec.Manifest, error) {
+	newLayers := make([]ispec.Descriptor, len(manifest.Layers))
+
+	for i, desc := range manifest.Layers {
+		if len(desc.Annotations) == 0 || (desc.Annotations[annotationKeysJWE] == "" &&
+			desc.Annotations[annotationKeysPGP] == "" && desc.Annotations[annotationKeysPKCS7] == "") {
+			newLayers[i] = desc
+			continue
+		}
+
+		dek, err := unwrapDEK(desc.Annotations, dc)
+		if err != nil {
+			return ispec.Manifest{}, errors.Wrapf(err, "unwrap DEK for layer %s", desc.Digest)
+		}
+
+		var opts pubOpts
+		rawOpts, err := base64.StdEncoding.DecodeString(desc.Annotations[annotationPubOpts])
+		if err != nil {
+			return ispec.Manifest{}, errors.Wrap(err, "decode pubopts")
+		}
+		if err := json.Unmarshal(rawOpts, &opts); err != nil {
+			return ispec.Manifest{}, errors.Wrap(err, "parse pubopts")
+		}
+
+		iv, err := base64.StdEncoding.DecodeString(opts.Hint[len("iv="):])
+		if err != nil {
+			return ispec.Manifest{}, errors.Wrap(err, "decode iv")
+		}
+
+		ciphertext, err := l.readBlob(desc)
+		if err != nil {
+			return ispec.Manifest{}, err
+		}
+
+		cleartext, err := decryptBlob(ciphertext, dek, iv, opts.Cipher)
+		if err != nil {
+			return ispec.Manifest{}, errors.Wrapf(err, "decrypt layer %s", desc.Digest)
+		}
+
+		plain, err := l.PutBlob(bytes.NewReader(cleartext))
+		if err != nil {
+			return ispec.Manifest{}, err
+		}
+		plainDigest, err := digest.Parse(plain.Hash)
+		if err != nil {
+			return ispec.Manifest{}, err
+		}
+
+		newLayers[i] = ispec.Descriptor{
+			MediaType: desc.MediaType[:len(desc.MediaType)-len(mediaTypeSuffixEncrypted)],
+			Digest:    plainDigest,
+			Size:      plain.Size,
+		}
+	}
+
+	manifest.Layers = newLayers
+	return manifest, nil
+}
@@ -0,0 +1,54 @@
+package umoci
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecryptBlobRoundTrip(t *testing.T) {
+	cleartext := []byte("hello stacker")
+
+	for _, algorithm := range []string{AlgorithmAESCTR, AlgorithmAESGCM} {
+		t.Run(algorithm, func(t *testing.T) {
+			ciphertext, dek, iv, err := encryptBlob(cleartext, algorithm)
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+			if bytes.Equal(ciphertext, cleartext) {
+				t.Fatalf("ciphertext equals cleartext")
+			}
+
+			got, err := decryptBlob(ciphertext, dek, iv, algorithm)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, cleartext) {
+				t.Fatalf("roundtrip mismatch: got %q, want %q", got, cleartext)
+			}
+		})
+	}
+}
+
+func TestEncryptBlobUnknownAlgorithm(t *testing.T) {
+	if _, _, _, err := encryptBlob([]byte("hello"), "rot13"); err == nil {
+		t.Fatalf("expected an error for an unsupported algorithm")
+	}
+}
+
+func TestDecryptBlobAlgorithmMismatch(t *testing.T) {
+	cleartext := []byte("hello stacker")
+
+	ciphertext, dek, iv, err := encryptBlob(cleartext, AlgorithmAESGCM)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	// Decrypting a GCM blob as CTR shouldn't reproduce the cleartext: CTR
+	// has no authentication tag to reject it outright, so this is the
+	// regression test for the bug where the cipher used never matched
+	// the cipher recorded in the layer's pubopts annotation.
+	got, err := decryptBlob(ciphertext, dek, iv, AlgorithmAESCTR)
+	if err == nil && bytes.Equal(got, cleartext) {
+		t.Fatalf("decrypting a GCM blob as CTR should not reproduce the cleartext")
+	}
+}
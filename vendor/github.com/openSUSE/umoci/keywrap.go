@@ -0,0 +1,184 @@
+package umoci
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+
+	"go.mozilla.org/pkcs7"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	josecipher "gopkg.in/square/go-jose.v2"
+)
+
+// wrapPGP encrypts dek to the given armored PGP public key.
+func wrapPGP(dek []byte, armoredKey []byte) ([]byte, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := openpgp.Encrypt(buf, keyring, nil, nil, &packet.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(dek); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unwrapPGP decrypts an armored PGP message (encoded as wrapped) using
+// armoredPrivKey, returning the DEK.
+func unwrapPGP(wrapped []byte, armoredPrivKey []byte) ([]byte, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredPrivKey))
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := openpgp.ReadMessage(bytes.NewReader(wrapped), keyring, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := buf.ReadFrom(md.UnverifiedBody); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// wrapJWE encrypts dek into a compact JWE using the recipient's JWK.
+func wrapJWE(dek []byte, jwk []byte) ([]byte, error) {
+	var key interface{}
+	block, _ := pem.Decode(jwk)
+	if block != nil {
+		cert, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert
+	} else {
+		key = jwk
+	}
+
+	encrypter, err := josecipher.NewEncrypter(josecipher.A256GCM,
+		josecipher.Recipient{Algorithm: josecipher.RSA_OAEP, Key: key}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := encrypter.Encrypt(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	serialized, err := obj.CompactSerialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(serialized), nil
+}
+
+// unwrapJWE decrypts a compact JWE using the recipient's private key.
+func unwrapJWE(wrapped []byte, privKeyPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(privKeyPEM)
+	if block == nil {
+		return nil, errInvalidPrivateKey
+	}
+
+	privKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := josecipher.ParseEncrypted(string(wrapped))
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.Decrypt(privKey)
+}
+
+// wrapPKCS7 encrypts dek to the given PEM certificate using PKCS7
+// enveloped-data.
+func wrapPKCS7(dek []byte, certPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errInvalidCertificate
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkcs7.Encrypt(dek, []*x509.Certificate{cert})
+}
+
+// unwrapPKCS7 decrypts a PKCS7 enveloped-data message with a private key
+// loaded alongside its certificate.
+func unwrapPKCS7(wrapped []byte, privKeyAndCertPEM []byte) ([]byte, error) {
+	p7, err := pkcs7.Parse(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, key, err := parsePrivateKeyAndCert(privKeyAndCertPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return p7.Decrypt(cert, key)
+}
+
+func parsePrivateKeyAndCert(pemBytes []byte) (*x509.Certificate, interface{}, error) {
+	var cert *x509.Certificate
+	var key interface{}
+
+	rest := pemBytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			cert = c
+		default:
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			key = k
+		}
+	}
+
+	if cert == nil || key == nil {
+		return nil, nil, errInvalidPrivateKey
+	}
+
+	return cert, key, nil
+}
+
+var (
+	errInvalidPrivateKey  = errInvalid("not a valid PEM private key")
+	errInvalidCertificate = errInvalid("not a valid PEM certificate")
+)
+
+type errInvalid string
+
+func (e errInvalid) Error() string { return string(e) }
@@ -0,0 +1,249 @@
+package umoci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// LayerCompression selects how a layer tar stream is compressed before
+// being stored as an OCI blob.
+type LayerCompression string
+
+const (
+	// GzipCompression is the traditional, widely supported layer
+	// format, and is the default.
+	GzipCompression LayerCompression = "gzip"
+	// ZstdCompression stores the layer as a single zstd stream.
+	ZstdCompression LayerCompression = "zstd"
+	// ZstdChunkedCompression stores the layer as independently
+	// addressable zstd frames plus a table of contents, enabling
+	// partial/lazy pulls.
+	ZstdChunkedCompression LayerCompression = "zstd:chunked"
+)
+
+const (
+	zstdChunkedFooterSize = 40
+	zstdChunkedMagic      = 0x123456789ABCDEF0
+	zstdChunkedManifest   = 1
+
+	// chunkSize is the size of an individual content chunk; each is
+	// compressed as its own zstd frame so a byte-range fetch of a
+	// single frame decompresses cleanly.
+	chunkSize = 1 << 20 // 1MiB
+
+	annotationTOCChecksum = "io.github.containers.zstd-chunked.manifest-checksum"
+	annotationTOCPosition = "io.github.containers.zstd-chunked.manifest-position"
+)
+
+// tocEntry describes a single file (or one chunk of a file) within a
+// zstd:chunked layer.
+type tocEntry struct {
+	Name        string `json:"name"`
+	Type        byte   `json:"type"`
+	Size        int64  `json:"size"`
+	UID         int    `json:"uid"`
+	GID         int    `json:"gid"`
+	Mode        int64  `json:"mode"`
+	Mtime       int64  `json:"mtime"`
+	Digest      string `json:"digest"`
+	ChunkSize   int64  `json:"chunk_size"`
+	ChunkDigest string `json:"chunk_digest"`
+	Offset      int64  `json:"offset"`
+}
+
+type tableOfContents struct {
+	Entries []tocEntry `json:"entries"`
+}
+
+// layerMediaType is the OCI image layer media type for a given
+// LayerCompression; zstd and zstd:chunked share a media type since
+// zstd:chunked is just zstd with a table-of-contents appended, which any
+// zstd decoder can ignore.
+func layerMediaType(compression LayerCompression) string {
+	switch compression {
+	case ZstdCompression, ZstdChunkedCompression:
+		return "application/vnd.oci.image.layer.v1.tar+zstd"
+	default:
+		return "application/vnd.oci.image.layer.v1.tar+gzip"
+	}
+}
+
+// PutLayer writes tarStream to the CAS compressed as compression, returning
+// the resulting Blob, the descriptor annotations (if any) that must be
+// attached alongside it, and the OCI layer media type to use for the
+// descriptor.
+func (l *Layout) PutLayer(tarStream io.Reader, compression LayerCompression) (Blob, map[string]string, string, error) {
+	switch compression {
+	case ZstdChunkedCompression:
+		blob, annotations, err := l.PutChunkedLayer(tarStream)
+		return blob, annotations, layerMediaType(compression), err
+
+	case ZstdCompression:
+		content, err := ioutil.ReadAll(tarStream)
+		if err != nil {
+			return Blob{}, nil, "", err
+		}
+
+		buf := &bytes.Buffer{}
+		if _, err := writeZstdFrame(buf, content); err != nil {
+			return Blob{}, nil, "", err
+		}
+		blob, err := l.PutBlob(buf)
+		return blob, nil, layerMediaType(compression), err
+
+	case GzipCompression, "":
+		buf := &bytes.Buffer{}
+		gzw := gzip.NewWriter(buf)
+		if _, err := io.Copy(gzw, tarStream); err != nil {
+			return Blob{}, nil, "", err
+		}
+		if err := gzw.Close(); err != nil {
+			return Blob{}, nil, "", err
+		}
+		blob, err := l.PutBlob(buf)
+		return blob, nil, layerMediaType(GzipCompression), err
+
+	default:
+		return Blob{}, nil, "", fmt.Errorf("unknown layer compression %q", compression)
+	}
+}
+
+// PutChunkedLayer reads an uncompressed tar stream and writes it to the CAS
+// as a zstd:chunked blob: every regular file is split into independently
+// compressed chunkSize frames, followed by a final frame holding the
+// gzipped JSON table of contents and a fixed-size footer pointing at it.
+//
+// It returns the resulting Blob and the descriptor annotations that must
+// be attached to it (the TOC checksum and uncompressed size).
+func (l *Layout) PutChunkedLayer(tarStream io.Reader) (Blob, map[string]string, error) {
+	buf := &bytes.Buffer{}
+	toc := tableOfContents{}
+
+	tr := tar.NewReader(tarStream)
+	var offset int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Blob{}, nil, err
+		}
+
+		entry := tocEntry{
+			Name:  hdr.Name,
+			Type:  hdr.Typeflag,
+			Size:  hdr.Size,
+			UID:   hdr.Uid,
+			GID:   hdr.Gid,
+			Mode:  hdr.Mode,
+			Mtime: hdr.ModTime.Unix(),
+		}
+
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			toc.Entries = append(toc.Entries, entry)
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return Blob{}, nil, err
+		}
+
+		sum := sha256.Sum256(content)
+		entry.Digest = "sha256:" + fmt.Sprintf("%x", sum)
+
+		for i := 0; i < len(content); i += chunkSize {
+			end := i + chunkSize
+			if end > len(content) {
+				end = len(content)
+			}
+			chunk := content[i:end]
+
+			chunkEntry := entry
+			chunkEntry.ChunkSize = int64(len(chunk))
+			chunkSum := sha256.Sum256(chunk)
+			chunkEntry.ChunkDigest = "sha256:" + fmt.Sprintf("%x", chunkSum)
+			chunkEntry.Offset = offset
+
+			n, err := writeZstdFrame(buf, chunk)
+			if err != nil {
+				return Blob{}, nil, err
+			}
+			offset += int64(n)
+
+			toc.Entries = append(toc.Entries, chunkEntry)
+		}
+	}
+
+	tocJSON, err := json.Marshal(toc)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	tocGzip := &bytes.Buffer{}
+	gzw := gzip.NewWriter(tocGzip)
+	if _, err := gzw.Write(tocJSON); err != nil {
+		return Blob{}, nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return Blob{}, nil, err
+	}
+
+	tocOffset := offset
+	tocLen, err := writeZstdFrame(buf, tocGzip.Bytes())
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	footer := make([]byte, zstdChunkedFooterSize)
+	binary.LittleEndian.PutUint64(footer[0:8], uint64(tocOffset))
+	binary.LittleEndian.PutUint64(footer[8:16], uint64(tocLen))
+	binary.LittleEndian.PutUint64(footer[16:24], uint64(len(tocJSON)))
+	binary.LittleEndian.PutUint64(footer[24:32], uint64(zstdChunkedManifest))
+	binary.LittleEndian.PutUint64(footer[32:40], uint64(zstdChunkedMagic))
+	buf.Write(footer)
+
+	blob, err := l.PutBlob(buf)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	tocSum := sha256.Sum256(tocJSON)
+	blob.Annotations = map[string]string{
+		annotationTOCChecksum: "sha256:" + fmt.Sprintf("%x", tocSum),
+		annotationTOCPosition: fmt.Sprintf("%d", len(tocJSON)),
+	}
+
+	return blob, blob.Annotations, nil
+}
+
+// writeZstdFrame compresses content as a single, independent zstd frame and
+// appends it to w, returning the number of compressed bytes written so the
+// caller can track the frame's offset in the blob.
+func writeZstdFrame(w io.Writer, content []byte) (int, error) {
+	frame := &bytes.Buffer{}
+
+	enc, err := zstd.NewWriter(frame)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := enc.Write(content); err != nil {
+		enc.Close()
+		return 0, err
+	}
+	if err := enc.Close(); err != nil {
+		return 0, err
+	}
+
+	return w.Write(frame.Bytes())
+}
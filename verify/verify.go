@@ -0,0 +1,511 @@
+// Package verify implements signature verification for stacker imports,
+// compatible with cosign/sigstore detached signatures. It supports both
+// keyed verification (a plain ECDSA/RSA public key) and keyless
+// verification (a Fulcio-issued certificate whose inclusion is attested by
+// a Rekor transparency log entry).
+package verify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/anuvu/stacker/types"
+	"github.com/pkg/errors"
+)
+
+// Options describes how a single import should be verified. It is derived
+// directly from the stackerfile's ImportMap.
+type Options struct {
+	// Sigstore is the path to the detached cosign/sigstore signature
+	// (base64-encoded) over the simple-signing envelope.
+	Sigstore string
+
+	// Cert is the path to the signing certificate, only used for
+	// keyless verification. When set, CosignKey is ignored.
+	Cert string
+
+	// Identity is the expected SAN (email or URI) of Cert, required
+	// when doing keyless verification.
+	Identity string
+
+	// CosignKey is the path to a PEM-encoded ECDSA or RSA public key,
+	// used for keyed verification.
+	CosignKey string
+}
+
+// Verify checks that the signature referenced by opts was produced over
+// payloadPath, failing closed: any error here should abort the build.
+func Verify(sc types.StackerConfig, payloadPath string, opts Options) error {
+	if opts.Sigstore == "" {
+		return errors.Errorf("no signature given for %s", payloadPath)
+	}
+
+	digest, err := sha256Digest(payloadPath)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't hash %s", payloadPath)
+	}
+
+	sigBytes, err := ioutil.ReadFile(opts.Sigstore)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't read signature %s", opts.Sigstore)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(string(sigBytes))
+	if err != nil {
+		// cosign also accepts a raw (non-base64) signature file.
+		sig = sigBytes
+	}
+
+	env, err := buildSimpleSigningEnvelope(digest)
+	if err != nil {
+		return err
+	}
+
+	if opts.Cert != "" {
+		return verifyKeyless(sc, env, sig, opts.Cert, opts.Identity)
+	}
+
+	if opts.CosignKey != "" {
+		return verifyKeyed(env, sig, opts.CosignKey)
+	}
+
+	return errors.Errorf("import %s has a sigstore signature but neither cert nor cosign_key was given", payloadPath)
+}
+
+func sha256Digest(p string) (string, error) {
+	contents, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(contents)
+	return "sha256:" + hexEncode(sum[:]), nil
+}
+
+func hexEncode(b []byte) string {
+	const hextable = "0123456789abcdef"
+	out := make([]byte, len(b)*2)
+	for i, v := range b {
+		out[i*2] = hextable[v>>4]
+		out[i*2+1] = hextable[v&0x0f]
+	}
+	return string(out)
+}
+
+// simpleSigningEnvelope is cosign's "simple signing" payload format: the
+// thing that actually gets signed is the JSON-serialized form of this
+// struct.
+type simpleSigningEnvelope struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional,omitempty"`
+}
+
+func buildSimpleSigningEnvelope(digest string) ([]byte, error) {
+	env := simpleSigningEnvelope{}
+	env.Critical.Type = "cosign container image signature"
+	env.Critical.Image.DockerManifestDigest = digest
+	return json.Marshal(env)
+}
+
+// verifyKeyed verifies sig over env using a PEM-encoded ECDSA or RSA public
+// key read from keyPath.
+func verifyKeyed(env []byte, sig []byte, keyPath string) error {
+	pub, err := loadPublicKey(keyPath)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't load cosign key %s", keyPath)
+	}
+
+	h := sha256.Sum256(env)
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, h[:], sig) {
+			return errors.Errorf("signature verification failed for %s", keyPath)
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig); err != nil {
+			return errors.Wrapf(err, "signature verification failed for %s", keyPath)
+		}
+	default:
+		return errors.Errorf("unsupported public key type %T in %s", pub, keyPath)
+	}
+
+	return nil
+}
+
+func loadPublicKey(keyPath string) (crypto.PublicKey, error) {
+	contents, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, errors.Errorf("%s is not PEM encoded", keyPath)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verifyKeyless verifies sig over env using the certificate at certPath: the
+// certificate must chain to the Fulcio CA chain (fetched and cached by
+// fulcioChain), its SAN must match identity, and a matching Rekor entry
+// must carry a valid signed entry timestamp (SET). No Merkle inclusion
+// proof is checked.
+func verifyKeyless(sc types.StackerConfig, env []byte, sig []byte, certPath string, identity string) error {
+	if identity == "" {
+		return errors.Errorf("keyless verification of %s requires an identity", certPath)
+	}
+
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't read cert %s", certPath)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return errors.Errorf("%s is not PEM encoded", certPath)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse cert %s", certPath)
+	}
+
+	roots, intermediates, err := fulcioChain(sc)
+	if err != nil {
+		return errors.Wrap(err, "couldn't load fulcio chain")
+	}
+
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return errors.Wrap(err, "cert does not chain to the fulcio root")
+	}
+
+	if err := certMatchesIdentity(cert, identity); err != nil {
+		return err
+	}
+
+	if err := verifyRekorInclusion(sc, env, sig, certPEM); err != nil {
+		return errors.Wrap(err, "rekor inclusion check failed")
+	}
+
+	return verifyKeyedWithCert(env, sig, cert)
+}
+
+func verifyKeyedWithCert(env []byte, sig []byte, cert *x509.Certificate) error {
+	h := sha256.Sum256(env)
+
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, h[:], sig) {
+			return errors.Errorf("signature verification failed against cert")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig); err != nil {
+			return errors.Wrap(err, "signature verification failed against cert")
+		}
+	default:
+		return errors.Errorf("unsupported public key type %T in cert", key)
+	}
+
+	return nil
+}
+
+// certMatchesIdentity checks that one of the cert's email SANs or URI SANs
+// matches identity. OtherName SANs are not examined.
+func certMatchesIdentity(cert *x509.Certificate, identity string) error {
+	for _, email := range cert.EmailAddresses {
+		if email == identity {
+			return nil
+		}
+	}
+
+	for _, uri := range cert.URIs {
+		if uri.String() == identity {
+			return nil
+		}
+	}
+
+	return errors.Errorf("cert identity does not match %s", identity)
+}
+
+// rekorLogEntry is the shape of a single entry returned by
+// /api/v1/log/entries/{uuid}: the base64-encoded hashedrekord body, plus
+// the signed entry timestamp (SET) Rekor computed over that body.
+type rekorLogEntry struct {
+	Body         string `json:"body"`
+	Verification struct {
+		SignedEntryTimestamp string `json:"signedEntryTimestamp"`
+	} `json:"verification"`
+}
+
+// rekorHashedRekord is the minimal shape of a Rekor "hashedrekord" entry
+// body: enough to confirm an entry returned for our search actually
+// attests to this exact signature/cert/digest, not just some unrelated
+// entry that happens to satisfy the query.
+type rekorHashedRekord struct {
+	Spec struct {
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+		Data struct {
+			Hash struct {
+				Value string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+	} `json:"spec"`
+}
+
+// verifyRekorInclusion looks up the log entry for this signature/cert and
+// verifies the Rekor SET against the (cached) Rekor public key.
+func verifyRekorInclusion(sc types.StackerConfig, env []byte, sig []byte, certPEM []byte) error {
+	rekorPub, err := rekorPublicKey(sc)
+	if err != nil {
+		return errors.Wrap(err, "couldn't load rekor public key")
+	}
+
+	entry, err := fetchRekorEntry(env, sig, certPEM)
+	if err != nil {
+		return errors.Wrap(err, "couldn't fetch rekor entry")
+	}
+
+	setSig, err := base64.StdEncoding.DecodeString(entry.Verification.SignedEntryTimestamp)
+	if err != nil {
+		return errors.Wrap(err, "couldn't decode rekor SET")
+	}
+
+	h := sha256.Sum256([]byte(entry.Body))
+
+	switch key := rekorPub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, h[:], setSig) {
+			return errors.Errorf("rekor SET verification failed")
+		}
+	default:
+		return errors.Errorf("unsupported rekor public key type %T", key)
+	}
+
+	return nil
+}
+
+const rekorSearchURL = "https://rekor.sigstore.dev/api/v1/index/retrieve"
+const rekorEntriesURL = "https://rekor.sigstore.dev/api/v1/log/entries"
+
+// fetchRekorEntry searches the public Rekor log for entries indexed under
+// this signature's digest, then fetches each candidate and checks that
+// its hashedrekord body actually binds this exact digest, signature and
+// cert together - not just that Rekor returned *some* entry for the
+// query. It is a var so that Verify() can be exercised without real
+// network access in tests.
+var fetchRekorEntry = func(env []byte, sig []byte, certPEM []byte) (*rekorLogEntry, error) {
+	digest := sha256.Sum256(env)
+	digestHex := hexEncode(digest[:])
+
+	uuids, err := rekorSearchByHash(digestHex)
+	if err != nil {
+		return nil, err
+	}
+	if len(uuids) == 0 {
+		return nil, errors.Errorf("no rekor entry indexed for this signature")
+	}
+
+	sigB64 := base64.StdEncoding.EncodeToString(sig)
+	certB64 := base64.StdEncoding.EncodeToString(certPEM)
+
+	for _, uuid := range uuids {
+		entry, record, err := rekorGetEntry(uuid)
+		if err != nil {
+			return nil, err
+		}
+
+		if record.Spec.Data.Hash.Value == digestHex &&
+			record.Spec.Signature.Content == sigB64 &&
+			record.Spec.Signature.PublicKey.Content == certB64 {
+			return entry, nil
+		}
+	}
+
+	return nil, errors.Errorf("no rekor entry matches this signature/cert/digest")
+}
+
+// rekorSearchByHash queries Rekor's index for every entry recorded
+// against the sha256 digest digestHex, returning their UUIDs.
+func rekorSearchByHash(digestHex string) ([]string, error) {
+	reqBody, err := json.Marshal(map[string]string{"hash": "sha256:" + digestHex})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(rekorSearchURL, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("rekor search returned status %d", resp.StatusCode)
+	}
+
+	var uuids []string
+	if err := json.NewDecoder(resp.Body).Decode(&uuids); err != nil {
+		return nil, err
+	}
+
+	return uuids, nil
+}
+
+// rekorGetEntry fetches and decodes a single log entry by UUID, along
+// with its parsed hashedrekord body.
+func rekorGetEntry(uuid string) (*rekorLogEntry, *rekorHashedRekord, error) {
+	resp, err := http.Get(rekorEntriesURL + "/" + uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errors.Errorf("rekor returned status %d fetching entry %s", resp.StatusCode, uuid)
+	}
+
+	var entries map[string]rekorLogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, nil, err
+	}
+
+	entry, ok := entries[uuid]
+	if !ok {
+		return nil, nil, errors.Errorf("rekor response didn't include entry %s", uuid)
+	}
+
+	bodyBytes, err := base64.StdEncoding.DecodeString(entry.Body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decode entry body")
+	}
+
+	var record rekorHashedRekord
+	if err := json.Unmarshal(bodyBytes, &record); err != nil {
+		return nil, nil, errors.Wrap(err, "parse hashedrekord body")
+	}
+
+	return &entry, &record, nil
+}
+
+const fulcioRootURL = "https://fulcio.sigstore.dev/api/v1/rootCert"
+const rekorPubKeyURL = "https://rekor.sigstore.dev/api/v1/log/publicKey"
+
+// fulcioChain returns the (cached) Fulcio CA chain - one self-signed root
+// plus zero or more intermediates - fetching and caching it under
+// sc.StackerDir the first time it's needed. The rootCert endpoint returns
+// the whole chain concatenated as PEM, not just the root, so a leaf
+// signed by an intermediate (rather than directly by the root) still
+// verifies.
+func fulcioChain(sc types.StackerConfig) (*x509.CertPool, *x509.CertPool, error) {
+	contents, err := cachedFetch(sc, "fulcio_root.pem", fulcioRootURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+
+	rest := contents
+	found := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		found++
+
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	if found == 0 {
+		return nil, nil, errors.Errorf("cached fulcio root is not PEM encoded")
+	}
+
+	return roots, intermediates, nil
+}
+
+// rekorPublicKey returns the (cached) Rekor public key, fetching and
+// caching it under sc.StackerDir the first time it's needed.
+func rekorPublicKey(sc types.StackerConfig) (crypto.PublicKey, error) {
+	contents, err := cachedFetch(sc, "rekor_pub.pem", rekorPubKeyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, errors.Errorf("cached rekor public key is not PEM encoded")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// cachedFetch returns the contents of sc.StackerDir/sigstore/name, fetching
+// it from url and populating the cache if it isn't there yet.
+func cachedFetch(sc types.StackerConfig, name string, url string) ([]byte, error) {
+	cacheDir := path.Join(sc.StackerDir, "sigstore")
+	cachePath := path.Join(cacheDir, name)
+
+	if contents, err := ioutil.ReadFile(cachePath); err == nil {
+		return contents, nil
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetching %s: status %d", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(cachePath, body, 0644); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
@@ -0,0 +1,181 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+)
+
+// writeECDSAPublicKey PEM-encodes pub and writes it to dir/name, returning
+// the path, in the same form loadPublicKey expects to read back.
+func writeECDSAPublicKey(t *testing.T, dir string, name string, pub *ecdsa.PublicKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("couldn't marshal public key: %v", err)
+	}
+
+	keyPath := path.Join(dir, name)
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := ioutil.WriteFile(keyPath, pemBytes, 0644); err != nil {
+		t.Fatalf("couldn't write %s: %v", keyPath, err)
+	}
+
+	return keyPath
+}
+
+func TestVerifyKeyedHappyPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_verify_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	keyPath := writeECDSAPublicKey(t, dir, "cosign.pub", &priv.PublicKey)
+
+	env := []byte(`{"critical":{"type":"cosign container image signature"}}`)
+	h := sha256.Sum256(env)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, h[:])
+	if err != nil {
+		t.Fatalf("couldn't sign: %v", err)
+	}
+
+	if err := verifyKeyed(env, sig, keyPath); err != nil {
+		t.Errorf("verifyKeyed failed on a genuine signature: %v", err)
+	}
+}
+
+func TestVerifyKeyedTamperedSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_verify_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	keyPath := writeECDSAPublicKey(t, dir, "cosign.pub", &priv.PublicKey)
+
+	env := []byte(`{"critical":{"type":"cosign container image signature"}}`)
+	h := sha256.Sum256(env)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, h[:])
+	if err != nil {
+		t.Fatalf("couldn't sign: %v", err)
+	}
+
+	// Flip a byte of the signature: it should no longer verify.
+	sig[len(sig)-1] ^= 0xff
+
+	if err := verifyKeyed(env, sig, keyPath); err == nil {
+		t.Errorf("verifyKeyed accepted a tampered signature")
+	}
+}
+
+func TestVerifyKeyedTamperedEnvelope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_verify_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	keyPath := writeECDSAPublicKey(t, dir, "cosign.pub", &priv.PublicKey)
+
+	env := []byte(`{"critical":{"type":"cosign container image signature"}}`)
+	h := sha256.Sum256(env)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, h[:])
+	if err != nil {
+		t.Fatalf("couldn't sign: %v", err)
+	}
+
+	tamperedEnv := []byte(`{"critical":{"type":"something else entirely"}}`)
+
+	if err := verifyKeyed(tamperedEnv, sig, keyPath); err == nil {
+		t.Errorf("verifyKeyed accepted a signature over a different envelope")
+	}
+}
+
+func TestVerifyKeyedWrongKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacker_verify_test")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	// Public key written to disk belongs to a different key pair than
+	// the one that actually signed env below.
+	keyPath := writeECDSAPublicKey(t, dir, "cosign.pub", &other.PublicKey)
+
+	env := []byte(`{"critical":{"type":"cosign container image signature"}}`)
+	h := sha256.Sum256(env)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, h[:])
+	if err != nil {
+		t.Fatalf("couldn't sign: %v", err)
+	}
+
+	if err := verifyKeyed(env, sig, keyPath); err == nil {
+		t.Errorf("verifyKeyed accepted a signature from an unrelated key")
+	}
+}
+
+func TestCertMatchesIdentityEmail(t *testing.T) {
+	cert := &x509.Certificate{EmailAddresses: []string{"builder@example.com"}}
+
+	if err := certMatchesIdentity(cert, "builder@example.com"); err != nil {
+		t.Errorf("certMatchesIdentity rejected a matching email SAN: %v", err)
+	}
+
+	if err := certMatchesIdentity(cert, "someone-else@example.com"); err == nil {
+		t.Errorf("certMatchesIdentity accepted a non-matching identity")
+	}
+}
+
+func TestCertMatchesIdentityURI(t *testing.T) {
+	u, err := url.Parse("https://github.com/anuvu/stacker/.github/workflows/build.yml@refs/heads/main")
+	if err != nil {
+		t.Fatalf("couldn't parse URI: %v", err)
+	}
+	cert := &x509.Certificate{URIs: []*url.URL{u}}
+
+	if err := certMatchesIdentity(cert, u.String()); err != nil {
+		t.Errorf("certMatchesIdentity rejected a matching URI SAN: %v", err)
+	}
+
+	if err := certMatchesIdentity(cert, "https://github.com/someone-else/other"); err == nil {
+		t.Errorf("certMatchesIdentity accepted a non-matching identity")
+	}
+}
+
+func TestCertMatchesIdentityNoSANs(t *testing.T) {
+	cert := &x509.Certificate{}
+
+	if err := certMatchesIdentity(cert, "builder@example.com"); err == nil {
+		t.Errorf("certMatchesIdentity accepted an identity against a cert with no SANs at all")
+	}
+}